@@ -0,0 +1,57 @@
+// Package main implements a generator of icon pack banners.
+// It uploads banners to the bucket where the site looks for them before generating its own.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/bcmk/siren/lib/cmdlib"
+	"github.com/spf13/pflag"
+)
+
+var checkErr = cmdlib.CheckErr
+
+var packFlag = pflag.String("pack", "", "generate banners only for this pack")
+var outFlag = pflag.String("out", "", "also save banners to this directory")
+var noUploadFlag = pflag.Bool("no-upload", false, "don't upload banners to the bucket")
+
+func main() {
+	cfg := sitelib.ReadConfig()
+	ctx := context.Background()
+	svc, err := sitelib.NewBucketClient(ctx, cfg)
+	checkErr(err)
+	packs, err := sitelib.LoadPacksV2(ctx, cfg)
+	checkErr(err)
+	fetch := sitelib.BucketFetcher(svc, cfg)
+	found := false
+	for _, pack := range packs {
+		if *packFlag != "" && pack.Name != *packFlag {
+			continue
+		}
+		found = true
+		for _, size := range sitelib.BannerSizes {
+			img, err := sitelib.RenderBanner(ctx, fetch, &pack, size)
+			checkErr(err)
+			data, err := sitelib.EncodeBanner(img)
+			checkErr(err)
+			key := sitelib.BannerKey(&pack, size)
+			if !*noUploadFlag {
+				checkErr(sitelib.Upload(ctx, svc, cfg, key, "image/jpeg", data))
+			}
+			if *outFlag != "" {
+				path := filepath.Join(*outFlag, pack.Name, fmt.Sprintf("%dx%d.jpg", size.Width, size.Height))
+				checkErr(os.MkdirAll(filepath.Dir(path), 0o755))
+				checkErr(os.WriteFile(path, data, 0o644))
+			}
+			slog.Info("banner generated", "pack", pack.Name, "key", key, "bytes", len(data))
+		}
+	}
+	if !found {
+		checkErr(fmt.Errorf("no pack %q", *packFlag))
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultReportDays = 30
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// adminAuth protects admin pages with HTTP basic authentication or a bearer token.
+// Admin pages don't exist unless credentials are configured.
+func (s *server) adminAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		basic := s.cfg.AdminUsername != "" && s.cfg.AdminPassword != ""
+		if !basic && len(s.cfg.AdminTokens) == 0 {
+			notFoundError(w)
+			return
+		}
+		if !s.adminAuthorized(r, basic) {
+			if basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="SIREN admin", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) adminAuthorized(r *http.Request, basic bool) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, allowed := range s.cfg.AdminTokens {
+			if allowed != "" && secureEqual(token, string(allowed)) {
+				return true
+			}
+		}
+		return false
+	}
+	if !basic {
+		return false
+	}
+	user, password, ok := r.BasicAuth()
+	return ok && secureEqual(user, s.cfg.AdminUsername) && secureEqual(password, string(s.cfg.AdminPassword))
+}
+
+// sameOrigin rejects cross-site form submissions.
+// Browsers resend basic credentials automatically, so they don't prove intent.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return true
+}
+
+func (s *server) adminAnalyticsHandler(w http.ResponseWriter, r *http.Request) error {
+	if !s.dbAvailable() {
+		return errDatabaseUnavailable
+	}
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 || days > 366 {
+		days = defaultReportDays
+	}
+	report, err := s.analyticsReport(r.Context(), days)
+	if err != nil {
+		return err
+	}
+	return render(w, r, s.adminAnalyticsTemplate, s.tparams(r, map[string]interface{}{"report": report}))
+}
+
+type adminPack struct {
+	Name             string
+	HumanName        string
+	Revision         int64
+	CreatedAt        time.Time
+	Icons            int
+	Likes            int
+	DisabledManifest bool
+	PublishAt        time.Time
+	UnpublishAt      time.Time
+	Published        bool
+	Override         packOverride
+	Warnings         []string
+	PreviewURL       string
+}
+
+func unixTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t, 0).UTC()
+}
+
+type adminVote struct {
+	Pack      string
+	Like      bool
+	Timestamp time.Time
+}
+
+const recentVotesLimit = 20
+
+func (s *server) recentVotes(ctx context.Context) ([]adminVote, error) {
+	rows, err := s.query(ctx, `select pack, "like", timestamp from likes order by timestamp desc limit $1`, recentVotesLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var votes []adminVote
+	for rows.Next() {
+		var vote adminVote
+		var timestamp int64
+		if err := rows.Scan(&vote.Pack, &vote.Like, &timestamp); err != nil {
+			return nil, err
+		}
+		vote.Timestamp = time.Unix(timestamp, 0).UTC()
+		votes = append(votes, vote)
+	}
+	return votes, rows.Err()
+}
+
+func (s *server) adminDashboardHandler(w http.ResponseWriter, r *http.Request) error {
+	data := map[string]interface{}{"reload_error": r.URL.Query().Get("reload_error")}
+
+	likes := map[string]int{}
+	if s.dbAvailable() {
+		var err error
+		if likes, err = s.likes(r.Context()); err != nil {
+			return err
+		}
+		if data["votes"], err = s.recentVotes(r.Context()); err != nil {
+			return err
+		}
+		if data["report"], err = s.analyticsReport(r.Context(), 7); err != nil {
+			return err
+		}
+	}
+
+	allPacks, _ := s.packState()
+	packs := make([]adminPack, 0, len(allPacks))
+	for _, pack := range allPacks {
+		override := s.packOverride(pack.Name)
+		packs = append(packs, adminPack{
+			Name:             pack.Name,
+			HumanName:        pack.HumanName,
+			Revision:         pack.Revision,
+			CreatedAt:        time.Unix(pack.CreatedAt, 0).UTC(),
+			Icons:            len(pack.Icons),
+			Likes:            likes[pack.Name],
+			DisabledManifest: s.manifestDisabled(pack.Name),
+			PublishAt:        unixTime(pack.PublishAt),
+			UnpublishAt:      unixTime(pack.UnpublishAt),
+			Published:        pack.Published(time.Now()),
+			Override:         override,
+			Warnings:         packWarnings(&pack),
+			PreviewURL:       s.previewURL(pack.Name),
+		})
+	}
+	data["packs"] = packs
+	data["packs_loaded_at"] = s.packsRefreshedAt().UTC()
+	data["code_cache"] = s.codeCache.stats()
+
+	config, err := json.MarshalIndent(s.cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	data["config"] = string(config)
+
+	return render(w, r, s.adminDashboardTemplate, s.tparams(r, data))
+}
+
+// adminPreviewHandler shows the pack page regardless of whether the pack is published.
+// Following pages get a preview token if previews are enabled
+// and are served under /admin otherwise.
+func (s *server) adminPreviewHandler(w http.ResponseWriter, r *http.Request) error {
+	pack := s.findAnyPack(mux.Vars(r)["pack"])
+	if pack == nil {
+		notFoundError(w)
+		return nil
+	}
+	preview := ""
+	if s.cfg.PreviewSecret != "" {
+		preview = s.previewToken(pack.Name, time.Now().Add(adminPreviewTTL))
+	}
+	return s.renderPack(w, r, s.enPackTemplate, "en", pack, preview)
+}
+
+func (s *server) adminReloadHandler(w http.ResponseWriter, r *http.Request) error {
+	target := "/admin"
+	if err := s.reloadPacks(r.Context()); err != nil {
+		requestLogger(r).Error("cannot reload packs", "error", err)
+		target += "?reload_error=" + url.QueryEscape(err.Error())
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+	return nil
+}
+
+// adminPackHandler changes the pack override according to the action
+func (s *server) adminPackHandler(w http.ResponseWriter, r *http.Request) error {
+	name := mux.Vars(r)["pack"]
+	if s.findAnyPack(name) == nil {
+		notFoundError(w)
+		return nil
+	}
+	if !s.dbAvailable() {
+		return errDatabaseUnavailable
+	}
+	override := s.packOverride(name)
+	switch action := mux.Vars(r)["action"]; action {
+	case "disable", "enable":
+		override.Disable = action == "disable"
+	case "pin", "unpin":
+		override.Pinned = action == "pin"
+	case "rename":
+		override.HumanName = strings.TrimSpace(r.PostFormValue("human_name"))
+	}
+	if err := s.savePackOverride(r.Context(), name, override); err != nil {
+		return err
+	}
+	requestLogger(r).Info("pack override changed", "pack", name, "disable", override.Disable, "pinned", override.Pinned, "human_name", override.HumanName)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	return nil
+}
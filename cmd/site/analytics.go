@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	analyticsFlushInterval = time.Minute
+	analyticsFlushTimeout  = 10 * time.Second
+	analyticsMaxKeys       = 10000
+
+	// otherSize is recorded for sizes the pack page doesn't offer
+	otherSize = -1
+)
+
+// codeSizes are sizes offered on the pack page
+var codeSizes = []int{42, 48, 54, 60, 66, 72, 78, 84, 90}
+
+// codeGenerationKey is an aggregation key of a code generation event.
+// It never contains parameter values, only the names of filled parameters.
+type codeGenerationKey struct {
+	day       string
+	pack      string
+	placement string
+	size      int
+	networks  string
+}
+
+// analyticsRecorder aggregates code generation events in memory
+// and periodically adds them to daily counters in the database
+type analyticsRecorder struct {
+	mu      sync.Mutex
+	pending map[codeGenerationKey]int
+	done    chan struct{}
+}
+
+func newAnalyticsRecorder() *analyticsRecorder {
+	return &analyticsRecorder{pending: map[codeGenerationKey]int{}, done: make(chan struct{})}
+}
+
+// filledNetworks returns sorted names of non-empty parameters except the layout ones
+func filledNetworks(params map[string]string) string {
+	var names []string
+	for _, p := range packParams {
+		if p == "placement" || p == "size" || p == "format" {
+			continue
+		}
+		if params[p] != "" {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// sizeLabel limits sizes to the known ones like placementLabel does,
+// zero means the default size
+func sizeLabel(size string) int {
+	if size == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(size)
+	if err == nil && slices.Contains(codeSizes, n) {
+		return n
+	}
+	return otherSize
+}
+
+func (a *analyticsRecorder) recordCodeGeneration(pack string, params map[string]string) {
+	key := codeGenerationKey{
+		day:       time.Now().UTC().Format(time.DateOnly),
+		pack:      pack,
+		placement: placementLabel(params["placement"]),
+		size:      sizeLabel(params["size"]),
+		networks:  filledNetworks(params),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.pending[key]; !ok && len(a.pending) >= analyticsMaxKeys {
+		return
+	}
+	a.pending[key]++
+}
+
+func (a *analyticsRecorder) take() map[codeGenerationKey]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pending := a.pending
+	a.pending = map[codeGenerationKey]int{}
+	return pending
+}
+
+// putBack returns counters that could not be stored
+func (a *analyticsRecorder) putBack(counters map[codeGenerationKey]int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, v := range counters {
+		if _, ok := a.pending[k]; !ok && len(a.pending) >= analyticsMaxKeys {
+			continue
+		}
+		a.pending[k] += v
+	}
+}
+
+// runAnalytics flushes aggregated events until ctx is done and then flushes the rest
+func (s *server) runAnalytics(ctx context.Context) {
+	defer close(s.analytics.done)
+	ticker := time.NewTicker(analyticsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), analyticsFlushTimeout)
+			s.flushAnalytics(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			s.flushAnalytics(ctx)
+		}
+	}
+}
+
+func (s *server) flushAnalytics(ctx context.Context) {
+	if !s.dbAvailable() {
+		return
+	}
+	counters := s.analytics.take()
+	for key, count := range counters {
+		err := s.exec(ctx, `
+			insert into code_generations (day, pack, placement, size, networks, count) values ($1, $2, $3, $4, $5, $6)
+			on conflict(day, pack, placement, size, networks) do update set count = code_generations.count + excluded.count`,
+			key.day,
+			key.pack,
+			key.placement,
+			key.size,
+			key.networks,
+			count,
+		)
+		if err != nil {
+			slog.Error("cannot store code generation counters", "error", err)
+			s.analytics.putBack(counters)
+			return
+		}
+		delete(counters, key)
+	}
+}
+
+type packGenerations struct {
+	Pack      string
+	Placement string
+	Count     int
+}
+
+type networksGenerations struct {
+	Networks string
+	Count    int
+}
+
+type sizeGenerations struct {
+	Size  int
+	Count int
+}
+
+// Label names the recorded size
+func (g sizeGenerations) Label() string {
+	switch g.Size {
+	case 0:
+		return "default"
+	case otherSize:
+		return "other"
+	}
+	return strconv.Itoa(g.Size) + "px"
+}
+
+type analyticsReport struct {
+	Days     int
+	Packs    []packGenerations
+	Sizes    []sizeGenerations
+	Networks []networksGenerations
+}
+
+func (s *server) analyticsReport(ctx context.Context, days int) (*analyticsReport, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format(time.DateOnly)
+	report := &analyticsReport{Days: days}
+	rows, err := s.query(ctx, `
+		select pack, placement, sum(count) from code_generations
+		where day >= $1
+		group by pack, placement
+		order by sum(count) desc`,
+		since)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p packGenerations
+		if err := rows.Scan(&p.Pack, &p.Placement, &p.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Packs = append(report.Packs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = s.query(ctx, `
+		select size, sum(count) from code_generations
+		where day >= $1
+		group by size
+		order by sum(count) desc`,
+		since)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var g sizeGenerations
+		if err := rows.Scan(&g.Size, &g.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Sizes = append(report.Sizes, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = s.query(ctx, `
+		select networks, sum(count) from code_generations
+		where day >= $1
+		group by networks
+		order by sum(count) desc
+		limit 50`,
+		since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n networksGenerations
+		if err := rows.Scan(&n.Networks, &n.Count); err != nil {
+			return nil, err
+		}
+		report.Networks = append(report.Networks, n)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// embeddedAssets contains templates, icons and the frontend build output.
+// The static and partial directories are produced by webpack,
+// so the frontend must be built before the binary.
+//
+//go:embed pages icons all:static all:partial
+var embeddedAssets embed.FS
+
+// openAssets returns the on-disk directory if it is set
+// and the assets embedded into the binary otherwise
+func openAssets(dir string) fs.FS {
+	if dir == "" {
+		return embeddedAssets
+	}
+	slog.Info("using assets from disk", "dir", dir)
+	return os.DirFS(dir)
+}
+
+func subAssets(assets fs.FS, dir string) http.FileSystem {
+	sub, err := fs.Sub(assets, dir)
+	checkErr(err)
+	return http.FS(sub)
+}
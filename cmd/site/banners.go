@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
+)
+
+func parseBannerSize(s string) (sitelib.BannerSize, bool) {
+	for _, size := range sitelib.BannerSizes {
+		if s == strconv.Itoa(size.Width)+"x"+strconv.Itoa(size.Height) {
+			return size, true
+		}
+	}
+	return sitelib.BannerSize{}, false
+}
+
+// bannerHandler generates the pack banner on demand.
+// Banners are immutable for the pack revision from the rev parameter.
+func (s *server) bannerHandler(w http.ResponseWriter, r *http.Request) error {
+	pack, preview := s.requestedPack(w, r)
+	size, ok := parseBannerSize(mux.Vars(r)["size"])
+	if pack == nil || !ok {
+		notFoundError(w)
+		return nil
+	}
+	data, err := s.generated(r.Context(), sitelib.BannerKey(pack, size), "image/jpeg", func(ctx context.Context) ([]byte, error) {
+		img, err := sitelib.RenderBanner(ctx, s.fetchIcon, pack, size)
+		if err != nil {
+			return nil, err
+		}
+		return sitelib.EncodeBanner(img)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		notFoundError(w)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	if preview == "" && r.URL.Query().Get("rev") == pack.BannerRevision() {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if preview == "" {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = w.Write(data)
+	return err
+}
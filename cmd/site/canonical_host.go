@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// localePrefixes maps languages to subdomains of the base domain
+var localePrefixes = map[string]string{"en": "", "ru": "ru."}
+
+func parseTrustedProxies(addresses []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, a := range addresses {
+		if !strings.Contains(a, "/") {
+			addr, err := netip.ParseAddr(a)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(a)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (s *server) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme trusts X-Forwarded-Proto only from configured proxies
+func (s *server) requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if s.fromTrustedProxy(r) {
+		if proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+func splitHostPort(hostport string) (host string, port string) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport, ""
+	}
+	return host, port
+}
+
+// canonicalHost strips www. and repeated or unknown subdomains of the base domain,
+// it returns false for hosts outside of the base domain
+func canonicalHost(host string, baseDomain string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if baseDomain == "" || (host != baseDomain && !strings.HasSuffix(host, "."+baseDomain)) {
+		return "", false
+	}
+	subdomains := strings.Split(strings.TrimSuffix(host, baseDomain), ".")
+	for _, sub := range subdomains {
+		if sub == "" {
+			continue
+		}
+		for _, prefix := range localePrefixes {
+			if prefix == sub+"." {
+				return prefix + baseDomain, true
+			}
+		}
+	}
+	return baseDomain, true
+}
+
+// canonicalHostHandler permanently redirects to the canonical host and HTTPS
+// if the base URL uses it. HTTPS is enforced only with trusted proxies configured,
+// otherwise the scheme of requests from a TLS-terminating proxy is unknown.
+// Hosts outside of the base domain such as probes addressing the pod directly
+// are served as is.
+func (s *server) canonicalHostHandler(h http.Handler) http.Handler {
+	https := strings.HasPrefix(s.cfg.BaseURL, "https://") && len(s.trustedProxies) > 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port := splitHostPort(r.Host)
+		canonical, ok := canonicalHost(host, s.cfg.BaseDomain)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		scheme := s.requestScheme(r)
+		if canonical == host && (!https || scheme == "https") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if https {
+			scheme, port = "https", ""
+		}
+		if port != "" {
+			canonical = net.JoinHostPort(canonical, port)
+		}
+		target := *r.URL
+		target.Scheme = scheme
+		target.Host = canonical
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target.String(), code)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	for _, c := range []struct {
+		host      string
+		canonical string
+		ok        bool
+	}{
+		{"siren.chat", "siren.chat", true},
+		{"www.siren.chat", "siren.chat", true},
+		{"WWW.Siren.Chat.", "siren.chat", true},
+		{"ru.siren.chat", "ru.siren.chat", true},
+		{"ru.ru.siren.chat", "ru.siren.chat", true},
+		{"www.ru.siren.chat", "ru.siren.chat", true},
+		{"unknown.siren.chat", "siren.chat", true},
+		{"example.com", "", false},
+		{"notsiren.chat", "", false},
+	} {
+		canonical, ok := canonicalHost(c.host, "siren.chat")
+		if canonical != c.canonical || ok != c.ok {
+			t.Errorf("%s: got %q %v, want %q %v", c.host, canonical, ok, c.canonical, c.ok)
+		}
+	}
+}
+
+func TestCanonicalHostHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	withProxies := &server{cfg: &sitelib.Config{BaseURL: "https://siren.chat", BaseDomain: "siren.chat"}}
+	var err error
+	if withProxies.trustedProxies, err = parseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	withoutProxies := &server{cfg: &sitelib.Config{BaseURL: "https://siren.chat", BaseDomain: "siren.chat"}}
+	for _, c := range []struct {
+		name     string
+		srv      *server
+		method   string
+		url      string
+		remote   string
+		proto    string
+		status   int
+		location string
+	}{
+		{"canonical", withProxies, http.MethodGet, "http://siren.chat/chic", "10.1.2.3:1000", "https", http.StatusOK, ""},
+		{"www", withProxies, http.MethodGet, "http://www.siren.chat/chic?a=1", "10.1.2.3:1000", "https", http.StatusMovedPermanently, "https://siren.chat/chic?a=1"},
+		{"double locale", withProxies, http.MethodGet, "http://ru.ru.siren.chat/chic", "10.1.2.3:1000", "https", http.StatusMovedPermanently, "https://ru.siren.chat/chic"},
+		{"post", withProxies, http.MethodPost, "http://www.ru.siren.chat/chic", "10.1.2.3:1000", "https", http.StatusPermanentRedirect, "https://ru.siren.chat/chic"},
+		{"foreign host", withProxies, http.MethodGet, "http://10.0.0.5:8080/healthz", "10.1.2.3:1000", "", http.StatusOK, ""},
+		{"plain HTTP from trusted proxy", withProxies, http.MethodGet, "http://siren.chat/chic", "127.0.0.1:1000", "http", http.StatusMovedPermanently, "https://siren.chat/chic"},
+		{"forged proto from untrusted peer", withProxies, http.MethodGet, "http://siren.chat/chic", "203.0.113.1:1000", "https", http.StatusMovedPermanently, "https://siren.chat/chic"},
+		{"no trusted proxies", withoutProxies, http.MethodGet, "http://siren.chat/chic", "10.1.2.3:1000", "", http.StatusOK, ""},
+		{"no trusted proxies www", withoutProxies, http.MethodGet, "http://www.siren.chat/chic", "10.1.2.3:1000", "", http.StatusMovedPermanently, "http://siren.chat/chic"},
+	} {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		req.RemoteAddr = c.remote
+		if c.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", c.proto)
+		}
+		rec := httptest.NewRecorder()
+		c.srv.canonicalHostHandler(ok).ServeHTTP(rec, req)
+		if rec.Code != c.status || rec.Header().Get("Location") != c.location {
+			t.Errorf("%s: got %d %q, want %d %q", c.name, rec.Code, rec.Header().Get("Location"), c.status, c.location)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+const (
+	defaultCodeCacheMaxEntries = 10000
+	defaultCodeCacheMaxBytes   = 32 << 20
+)
+
+type codeCacheKey struct {
+	pack     string
+	revision int64
+	params   string
+}
+
+type codeCacheEntry struct {
+	key  codeCacheKey
+	code string
+}
+
+type codeCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   int
+}
+
+// codeCache is an LRU cache of generated code limited by entries count and total size
+type codeCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	hits       uint64
+	misses     uint64
+	ll         *list.List
+	items      map[codeCacheKey]*list.Element
+}
+
+func newCodeCache(maxEntries, maxBytes int) *codeCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCodeCacheMaxEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultCodeCacheMaxBytes
+	}
+	return &codeCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      map[codeCacheKey]*list.Element{},
+	}
+}
+
+// newCodeCacheKey hashes normalized parameters in the fixed packParams order
+func newCodeCacheKey(pack *sitelib.PackV2, params map[string]string) codeCacheKey {
+	h := sha256.New()
+	for _, p := range packParams {
+		_, _ = h.Write([]byte(p))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(params[p]))
+		_, _ = h.Write([]byte{0})
+	}
+	return codeCacheKey{pack: pack.Name, revision: pack.Revision, params: hex.EncodeToString(h.Sum(nil))}
+}
+
+func (c *codeCache) get(key codeCacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.hits++
+		codeCacheRequests.WithLabelValues("hit").Inc()
+		c.ll.MoveToFront(el)
+		return el.Value.(*codeCacheEntry).code, true
+	}
+	c.misses++
+	codeCacheRequests.WithLabelValues("miss").Inc()
+	return "", false
+}
+
+func (c *codeCache) add(key codeCacheKey, code string) {
+	if len(code) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&codeCacheEntry{key: key, code: code})
+	c.bytes += len(code)
+	for c.ll.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// prune removes entries of packs that are gone or have a different revision now
+func (c *codeCache) prune(packs []sitelib.PackV2) {
+	revisions := map[string]int64{}
+	for _, p := range packs {
+		revisions[p.Name] = p.Revision
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if revision, ok := revisions[key.pack]; !ok || revision != key.revision {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *codeCache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*codeCacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= len(entry.code)
+}
+
+func (c *codeCache) stats() codeCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return codeCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.ll.Len(), Bytes: c.bytes}
+}
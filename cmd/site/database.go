@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type dbState int32
+
+const (
+	dbConnecting dbState = iota
+	dbUp
+	dbDown
+	dbDisabled
+)
+
+func (d dbState) String() string {
+	switch d {
+	case dbConnecting:
+		return "connecting"
+	case dbUp:
+		return "up"
+	case dbDown:
+		return "down"
+	case dbDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+const (
+	dbPingInterval = 15 * time.Second
+	dbPingTimeout  = 5 * time.Second
+	dbMaxBackoff   = time.Minute
+
+	// likesTimeout limits likes queries on public pages,
+	// pages are shown without likes if they don't finish in time
+	likesTimeout = 2 * time.Second
+)
+
+func (s *server) dbState() dbState {
+	return dbState(s.dbStatus.Load())
+}
+
+// dbAvailable reports whether s.db can be used.
+// s.db is assigned before the state becomes dbUp and never changes afterwards.
+func (s *server) dbAvailable() bool {
+	return s.dbState() == dbUp
+}
+
+func (s *server) setDBState(state dbState) {
+	if old := dbState(s.dbStatus.Swap(int32(state))); old != state {
+		slog.Info("database state changed", "from", old, "to", state)
+	}
+}
+
+// maintainDatabase connects to the database and applies migrations,
+// retrying in the background until it succeeds,
+// then keeps track of the database availability until ctx is done
+func (s *server) maintainDatabase(ctx context.Context) {
+	defer close(s.dbDone)
+	if s.cfg.ConnectionString == "" {
+		slog.Info("no connection string, running without database")
+		s.setDBState(dbDisabled)
+		return
+	}
+	backoff := time.Second
+	for {
+		err := s.connectDatabase(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("cannot connect to database", "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, dbMaxBackoff)
+	}
+	defer s.db.Close()
+	ticker := time.NewTicker(dbPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+			err := s.db.Ping(pingCtx)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				slog.Error("database ping failed", "error", err)
+				s.setDBState(dbDown)
+			} else if err == nil {
+				s.setDBState(dbUp)
+				s.refreshPackOverrides(ctx)
+			}
+		}
+	}
+}
+
+func (s *server) connectDatabase(ctx context.Context) error {
+	db, err := pgxpool.New(ctx, string(s.cfg.ConnectionString))
+	if err != nil {
+		return err
+	}
+	s.db = db
+	if err := s.createDatabase(ctx); err != nil {
+		db.Close()
+		return err
+	}
+	s.setDBState(dbUp)
+	s.refreshPackOverrides(ctx)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+const defaultPackLicense = `The icons are free to use on your profiles, pages and streams.
+Please don't sell them or redistribute them as your own work.`
+
+var packReadmeTemplate = template.Must(template.New("readme").Parse(`{{ .pack.HumanName }} icon pack by SIREN
+{{ .base_url }}/chic/p/{{ .pack.Name }}
+
+License
+-------
+{{ .license }}
+
+Sample HTML
+-----------
+{{ range .files -}}
+<a href="YOUR LINK"><img src="{{ . }}" height="54" alt=""></a>
+{{ end }}`))
+
+// packIconNames returns names of all the pack icons in the alphabetical order
+func packIconNames(pack *sitelib.PackV2) []string {
+	names := make([]string, 0, len(pack.Icons))
+	for name := range pack.Icons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// packArchive builds a ZIP of all the pack icons and a README
+func (s *server) packArchive(ctx context.Context, pack *sitelib.PackV2) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	modified := time.Unix(pack.CreatedAt, 0)
+	var files []string
+	for _, name := range packIconNames(pack) {
+		file := pack.VersionedIcon(name) + "." + pack.FinalType
+		var data []byte
+		var err error
+		if pack.FinalType == "svg" {
+			data, err = sitelib.SVGIcon(ctx, s.fetchIcon, pack, name)
+		} else if data, err = s.fetchIcon(ctx, pack.Name+"/"+file); err == nil && data == nil {
+			err = fs.ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: pack.Name + "/" + file, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	license := pack.License
+	if license == "" {
+		license = defaultPackLicense
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: pack.Name + "/README.txt", Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return nil, err
+	}
+	err = packReadmeTemplate.Execute(w, map[string]interface{}{
+		"pack":     pack,
+		"base_url": s.cfg.BaseURL,
+		"license":  license,
+		"files":    files,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// downloadHandler serves the ZIP of the pack, the archive is cached per pack revision
+func (s *server) downloadHandler(w http.ResponseWriter, r *http.Request) error {
+	pack, preview := s.requestedPack(w, r)
+	if pack == nil {
+		notFoundError(w)
+		return nil
+	}
+	key := pack.Name + "/downloads/" + pack.Name + ".r" + strconv.FormatInt(pack.Revision, 10) + ".zip"
+	data, err := s.generated(r.Context(), key, "application/zip", func(ctx context.Context) ([]byte, error) {
+		return s.packArchive(ctx, pack)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		notFoundError(w)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+pack.Name+`.zip"`)
+	w.Header().Set("ETag", `"`+pack.Name+"-r"+strconv.FormatInt(pack.Revision, 10)+`"`)
+	if preview == "" && !isAdminPreview(r) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	ht "html/template"
+	"net"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var errDatabaseUnavailable = errors.New("database is unavailable")
+
+// handlerFunc is an HTTP handler that reports failures instead of panicking
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// handle adapts handlerFunc to http.Handler.
+// Errors are logged with the route name and rendered as localized error pages.
+func (s *server) handle(route string, lang string, h handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err == nil {
+			return
+		}
+		status := http.StatusInternalServerError
+		if isUnavailable(err) {
+			status = http.StatusServiceUnavailable
+		}
+		requestLogger(r).Error("request failed", "route", route, "method", r.Method, "path", r.URL.Path, "status", status, "error", err)
+		s.errorPage(w, r, lang, status)
+	})
+}
+
+// isUnavailable reports whether the error is caused by an unreachable or overloaded dependency
+func isUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.Is(err, errDatabaseUnavailable) ||
+		errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		pgconn.Timeout(err) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+func (s *server) errorPage(w http.ResponseWriter, r *http.Request, lang string, status int) {
+	t := s.enErrorTemplate
+	if lang == "ru" {
+		t = s.ruErrorTemplate
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, s.tparams(r, map[string]interface{}{"status": status})); err != nil {
+		requestLogger(r).Error("cannot render error page", "error", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(b.Bytes())
+}
+
+// render executes the template into a buffer first,
+// so that a failure in the middle of the template doesn't produce a partial page
+func render(w http.ResponseWriter, r *http.Request, t *ht.Template, data interface{}) error {
+	_, span := tracer.Start(r.Context(), "template "+t.Name())
+	var b bytes.Buffer
+	err := t.Execute(&b, data)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write(b.Bytes())
+	return err
+}
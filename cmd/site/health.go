@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bcmk/siren/lib/cmdlib"
+)
+
+const readinessDBTimeout = time.Second
+
+type healthStatus struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+}
+
+type readinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type readinessStatus struct {
+	Status                string                    `json:"status"`
+	Checks                map[string]readinessCheck `json:"checks"`
+	PackRefreshAgeSeconds int64                     `json:"pack_refresh_age_seconds"`
+}
+
+type versionInfo struct {
+	Version    string `json:"version"`
+	Packs      int    `json:"packs"`
+	Icons      int    `json:"icons"`
+	ConfigHash string `json:"config_hash"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// configHash hashes the config as it is logged, so secret values don't affect it
+func configHash(cfg interface{}) string {
+	data, err := json.Marshal(cfg)
+	checkErr(err)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// healthzHandler reports that the process is alive.
+// The site keeps serving without the database, so its state is informational only.
+func (s *server) healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok", Database: s.dbState().String()})
+}
+
+// readyzHandler reports whether the site can serve pages.
+// The database check doesn't affect readiness as the site works without it in degraded mode.
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]readinessCheck{}
+	ready := true
+
+	allPacks, _ := s.packState()
+	packs := len(allPacks)
+	checks["packs"] = readinessCheck{OK: packs > 0, Detail: plural(packs, "pack")}
+	ready = ready && packs > 0
+
+	templates := s.enPackTemplate != nil && s.ruPackTemplate != nil && s.codeGeneratorTemplate != nil
+	checks["templates"] = readinessCheck{OK: templates}
+	ready = ready && templates
+
+	checks["database"] = s.checkDatabase(r.Context())
+
+	status := readinessStatus{
+		Status:                "ready",
+		Checks:                checks,
+		PackRefreshAgeSeconds: int64(time.Since(s.packsRefreshedAt()).Seconds()),
+	}
+	code := http.StatusOK
+	if !ready {
+		status.Status = "not ready"
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+func (s *server) checkDatabase(ctx context.Context) readinessCheck {
+	state := s.dbState()
+	if state != dbUp {
+		return readinessCheck{OK: state == dbDisabled, Detail: state.String()}
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessDBTimeout)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		return readinessCheck{OK: false, Detail: err.Error()}
+	}
+	return readinessCheck{OK: true, Detail: state.String()}
+}
+
+func (s *server) versionHandler(w http.ResponseWriter, _ *http.Request) {
+	packs, _ := s.packState()
+	writeJSON(w, http.StatusOK, versionInfo{
+		Version:    cmdlib.Version,
+		Packs:      len(packs),
+		Icons:      s.iconsCount(),
+		ConfigHash: s.configHash,
+	})
+}
+
+func plural(n int, what string) string {
+	if n == 1 {
+		return "1 " + what
+	}
+	return strconv.Itoa(n) + " " + what + "s"
+}
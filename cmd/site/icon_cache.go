@@ -0,0 +1,91 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIconCacheMaxBytes = 64 << 20
+	missingIconTTL           = time.Minute
+)
+
+type iconCacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+func (e *iconCacheEntry) size() int {
+	return len(e.key) + len(e.data)
+}
+
+// iconCache is an LRU cache of icons limited by total size.
+// Keys contain icon versions so entries never go stale,
+// only missing icons expire as they can be uploaded later.
+type iconCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newIconCache(maxBytes int) *iconCache {
+	if maxBytes <= 0 {
+		maxBytes = defaultIconCacheMaxBytes
+	}
+	return &iconCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *iconCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*iconCacheEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			iconCacheRequests.WithLabelValues("hit").Inc()
+			c.ll.MoveToFront(el)
+			return entry.data, true
+		}
+		c.remove(el)
+	}
+	iconCacheRequests.WithLabelValues("miss").Inc()
+	return nil, false
+}
+
+func (c *iconCache) add(key string, data []byte) {
+	c.put(&iconCacheEntry{key: key, data: data})
+}
+
+// addMissing remembers for a while that there is no such icon
+func (c *iconCache) addMissing(key string) {
+	c.put(&iconCacheEntry{key: key, expires: time.Now().Add(missingIconTTL)})
+}
+
+func (c *iconCache) put(entry *iconCacheEntry) {
+	if entry.size() > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[entry.key]; ok {
+		c.remove(el)
+	}
+	c.items[entry.key] = c.ll.PushFront(entry)
+	c.bytes += entry.size()
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *iconCache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*iconCacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
+)
+
+type requestIDKey struct{}
+
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9\-_.]{1,64}$`)
+
+func newLogger(cfg *sitelib.Config) *slog.Logger {
+	level := slog.LevelInfo
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactSecrets}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// redactSecrets hides secrets that were logged without LogValue being resolved,
+// e.g. nested inside groups
+func redactSecrets(_ []string, a slog.Attr) slog.Attr {
+	if _, ok := a.Value.Any().(sitelib.Secret); ok {
+		return slog.String(a.Key, sitelib.Secret("").String())
+	}
+	return a
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDHandler takes the request ID from the proxy or generates a new one
+// and makes it available to handlers and the access log
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRegex.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the default logger annotated with the request ID
+func requestLogger(r *http.Request) *slog.Logger {
+	if id := requestID(r.Context()); id != "" {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// requestLang returns the language the request is served in
+func (s *server) requestLang(r *http.Request) string {
+	if s.cfg.Lang != "" {
+		return s.cfg.Lang
+	}
+	if hostname(r) == "ru."+s.cfg.BaseDomain {
+		return "ru"
+	}
+	return "en"
+}
+
+func hostname(r *http.Request) string {
+	u := *r.URL
+	u.Host = r.Host
+	return u.Hostname()
+}
+
+func logAccess(r *http.Request, route string, lang string, status int, written int64, durationMs int64) {
+	attrs := []any{
+		"method", r.Method,
+		"route", route,
+		"path", r.URL.Path,
+		"status", status,
+		"bytes", written,
+		"duration_ms", durationMs,
+		"lang", lang,
+	}
+	if pack := mux.Vars(r)["pack"]; pack != "" {
+		attrs = append(attrs, "pack", pack)
+	}
+	requestLogger(r).Info("access", attrs...)
+}
@@ -10,18 +10,25 @@ import (
 	"fmt"
 	ht "html/template"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"mime"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"os"
-	"path/filepath"
+	"os/signal"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/aohorodnyk/mimeheader"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bcmk/siren-site/v3/sitelib"
 	"github.com/bcmk/siren/lib/cmdlib"
 	"github.com/gorilla/handlers"
@@ -29,15 +36,33 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/tdewolff/minify/v2"
 	hmin "github.com/tdewolff/minify/v2/html"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 
 	_ "image/png"
 )
 
 type server struct {
-	cfg          *sitelib.Config
-	enabledPacks []sitelib.PackV2
-	db           *pgxpool.Pool
-	packs        []sitelib.PackV2
+	cfg      *sitelib.Config
+	assets   fs.FS
+	db       *pgxpool.Pool
+	dbStatus atomic.Int32
+	dbDone   chan struct{}
+
+	trustedProxies []netip.Prefix
+
+	packsMu       sync.RWMutex
+	manifestPacks []sitelib.PackV2
+	packOverrides map[string]packOverride
+	packs         []sitelib.PackV2
+	enabledPacks  []sitelib.PackV2
+	packsLoadedAt time.Time
+
+	configHash string
+
+	// localizedRoutes are page routes served on every language domain
+	localizedRoutes []string
 
 	enIndexTemplate                 *ht.Template
 	ruIndexTemplate                 *ht.Template
@@ -53,6 +78,17 @@ type server struct {
 	ruPackTemplate                  *ht.Template
 	enCodeTemplate                  *ht.Template
 	ruCodeTemplate                  *ht.Template
+	enErrorTemplate                 *ht.Template
+	adminAnalyticsTemplate          *ht.Template
+	adminDashboardTemplate          *ht.Template
+	ruErrorTemplate                 *ht.Template
+	codeGeneratorTemplate           *ht.Template
+	minifier                        *minify.M
+	codeCache                       *codeCache
+	iconCache                       *iconCache
+	bucket                          *s3.Client
+	renders                         singleflight.Group
+	analytics                       *analyticsRecorder
 	bioHeaderRemover                string
 	partialFaviconsHTML             string
 	cssContent                      string
@@ -110,19 +146,20 @@ var funcMap = ht.FuncMap{
 		return x / y
 	},
 	"versioned": func(pack *sitelib.PackV2, name string) string {
-		icon := pack.Icons[name]
-		if icon.Version == 0 {
-			return name
-		}
-		return name + ".v" + strconv.Itoa(icon.Version)
+		return pack.VersionedIcon(name)
 	},
-	"make_slice": func(xs ...any) []any { return xs },
+	"make_slice":      func(xs ...any) []any { return xs },
+	"json_ld":         jsonLD,
+	"breadcrumb_list": breadcrumbList,
 	"atoi": func(s string) int {
 		if s == "" {
 			return 0
 		}
+		// parameters come from the query string, invalid numbers are ignored
 		n, err := strconv.Atoi(s)
-		checkErr(err)
+		if err != nil {
+			return 0
+		}
 		return n
 	},
 }
@@ -158,13 +195,11 @@ var packParams = []string{
 	"fanberry",
 	"placement",
 	"size",
+	"format",
 }
 
 var chaturbateModelRegex = regexp.MustCompile(`^(?:https?://)?(?:www\.|ar\.|de\.|el\.|en\.|es\.|fr\.|hi\.|it\.|ja\.|ko\.|nl\.|pt\.|ru\.|tr\.|zh\.|m\.)?chaturbate\.com(?:/p|/b)?/([A-Za-z0-9\-_@]+)/?(?:\?.*)?$|^([A-Za-z0-9\-_@]+)$`)
 
-func linf(format string, v ...interface{}) { log.Printf("[INFO] "+format, v...) }
-func ldbg(format string, v ...interface{}) { log.Printf("[DBG] "+format, v...) }
-
 var checkErr = cmdlib.CheckErr
 
 func notFoundError(w http.ResponseWriter) {
@@ -173,12 +208,12 @@ func notFoundError(w http.ResponseWriter) {
 	_, _ = fmt.Fprint(w, "404 not found")
 }
 
-func parseHTMLTemplate(filenames ...string) *ht.Template {
+func (s *server) parseHTMLTemplate(filenames ...string) *ht.Template {
 	var relative []string
 	for _, f := range filenames {
 		relative = append(relative, "pages/"+f)
 	}
-	t, err := ht.New(filepath.Base(filenames[0])).Funcs(funcMap).ParseFiles(relative...)
+	t, err := ht.New(path.Base(filenames[0])).Funcs(funcMap).ParseFS(s.assets, relative...)
 	checkErr(err)
 	return t
 }
@@ -203,24 +238,31 @@ func getLangBaseURL(url url.URL, baseDomain string, baseURL string) string {
 	return "https://" + url.Host
 }
 
+func (s *server) langBaseURL(r *http.Request) ht.URL {
+	urlCopy := *r.URL
+	urlCopy.Host = r.Host
+	return ht.URL(getLangBaseURL(urlCopy, s.cfg.BaseDomain, s.cfg.BaseURL))
+}
+
 func (s *server) tparams(r *http.Request, more map[string]interface{}) map[string]interface{} {
 	res := map[string]interface{}{}
 	urlCopy := *r.URL
 	res["full_path"] = urlCopy.String()
 	urlCopy.Host = r.Host
 	res["base_url"] = ht.URL(s.cfg.BaseURL)
-	res["lang_base_url"] = ht.URL(getLangBaseURL(urlCopy, s.cfg.BaseDomain, s.cfg.BaseURL))
+	res["lang_base_url"] = s.langBaseURL(r)
 	res["hostname"] = urlCopy.Hostname()
 	res["base_domain"] = s.cfg.BaseDomain
 	res["ru_domain"] = "ru." + s.cfg.BaseDomain
-	res["lang"] = langs(urlCopy, s.cfg.BaseDomain, map[string]string{"en": "", "ru": "ru."})
+	res["lang"] = langs(urlCopy, s.cfg.BaseDomain, localePrefixes)
 	res["version"] = cmdlib.Version
+	res["likes_enabled"] = s.dbAvailable()
+	res["csp_nonce"] = cspNonce(r.Context())
 	for k, v := range more {
 		res[k] = v
 	}
 	ah := mimeheader.ParseAcceptHeader(r.Header.Get("Accept"))
 	imgExts := map[string]string{}
-	imgExts["svg"] = "svgz"
 	if ah.Match("image/webp") {
 		imgExts["png"] = "webp"
 	} else {
@@ -235,67 +277,119 @@ func (s *server) tparams(r *http.Request, more map[string]interface{}) map[strin
 	return res
 }
 
-func (s *server) enIndexHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.enIndexTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) enIndexHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.enIndexTemplate, s.tparams(r, map[string]interface{}{"structured_data": s.organizationData()}))
 }
 
-func (s *server) ruIndexHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.ruIndexTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) ruIndexHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.ruIndexTemplate, s.tparams(r, map[string]interface{}{"structured_data": s.organizationData()}))
 }
 
-func (s *server) enStreamerHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.enStreamerTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) enStreamerHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.enStreamerTemplate, s.tparams(r, nil))
 }
 
-func (s *server) ruStreamerHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.ruStreamerTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) ruStreamerHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.ruStreamerTemplate, s.tparams(r, nil))
 }
 
-func (s *server) enStreamerNotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.enStreamerNotificationsTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) enStreamerNotificationsHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.enStreamerNotificationsTemplate, s.tparams(r, nil))
 }
 
-func (s *server) ruStreamerNotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.ruStreamerNotificationsTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) ruStreamerNotificationsHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.ruStreamerNotificationsTemplate, s.tparams(r, nil))
 }
 
-func (s *server) enStreamerChannelHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.enStreamerChannelTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) enStreamerChannelHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.enStreamerChannelTemplate, s.tparams(r, nil))
 }
 
-func (s *server) ruStreamerChannelHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.ruStreamerChannelTemplate.Execute(w, s.tparams(r, nil)))
+func (s *server) ruStreamerChannelHandler(w http.ResponseWriter, r *http.Request) error {
+	return render(w, r, s.ruStreamerChannelTemplate, s.tparams(r, nil))
 }
 
-func (s *server) enChicHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.enChicTemplate.Execute(w, s.tparams(r, map[string]interface{}{"packs": s.enabledPacks, "likes": s.likes()})))
+func (s *server) chicHandler(w http.ResponseWriter, r *http.Request, t *ht.Template) error {
+	likes := map[string]int{}
+	likesEnabled := s.dbAvailable()
+	if likesEnabled {
+		ctx, cancel := context.WithTimeout(r.Context(), likesTimeout)
+		defer cancel()
+		var err error
+		if likes, err = s.likes(ctx); err != nil {
+			requestLogger(r).Error("cannot query likes", "error", err)
+			likes, likesEnabled = map[string]int{}, false
+		}
+	}
+	_, enabledPacks := s.packState()
+	return render(w, r, t, s.tparams(r, map[string]interface{}{
+		"packs":         enabledPacks,
+		"likes":         likes,
+		"likes_enabled": likesEnabled,
+	}))
 }
 
-func (s *server) ruChicHandler(w http.ResponseWriter, r *http.Request) {
-	checkErr(s.ruChicTemplate.Execute(w, s.tparams(r, map[string]interface{}{"packs": s.enabledPacks, "likes": s.likes()})))
+func (s *server) enChicHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.chicHandler(w, r, s.enChicTemplate)
 }
 
-func (s *server) packHandler(w http.ResponseWriter, r *http.Request, t *ht.Template) {
-	pack := s.findPack(mux.Vars(r)["pack"])
+func (s *server) ruChicHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.chicHandler(w, r, s.ruChicTemplate)
+}
+
+func (s *server) packHandler(w http.ResponseWriter, r *http.Request, t *ht.Template, lang string) error {
+	pack, preview := s.requestedPack(w, r)
 	if pack == nil {
 		notFoundError(w)
-		return
+		return nil
 	}
+	return s.renderPack(w, r, t, lang, pack, preview)
+}
+
+func (s *server) renderPack(
+	w http.ResponseWriter,
+	r *http.Request,
+	t *ht.Template,
+	lang string,
+	pack *sitelib.PackV2,
+	preview string,
+) error {
 	sirenError := false
 	paramDict := getParamDict(packParams, r)
 	siren := paramDict["siren"]
 	if siren != "" && checkSirenParam(siren) == "" {
 		sirenError = true
+		sirenValidationFailures.Inc()
+	}
+	likes, dislikes := 0, 0
+	likesEnabled := s.dbAvailable()
+	if likesEnabled {
+		ctx, cancel := context.WithTimeout(r.Context(), likesTimeout)
+		defer cancel()
+		var err error
+		if likes, dislikes, err = s.votesForPack(ctx, pack.Name); err != nil {
+			requestLogger(r).Error("cannot query likes", "pack", pack.Name, "error", err)
+			likes, dislikes, likesEnabled = 0, 0, false
+		}
 	}
-	checkErr(t.Execute(w, s.tparams(r, map[string]interface{}{"pack": pack, "params": paramDict, "likes": s.likesForPack(pack.Name), "siren_error": sirenError})))
+	return render(w, r, t, s.tparams(r, map[string]interface{}{
+		"pack":            pack,
+		"params":          paramDict,
+		"likes":           likes - dislikes,
+		"likes_enabled":   likesEnabled,
+		"siren_error":     sirenError,
+		"preview":         preview,
+		"links":           newPackLinks(r, pack, preview),
+		"structured_data": s.packData(s.langBaseURL(r), lang, pack, likes, dislikes),
+	}))
 }
 
-func (s *server) enPackHandler(w http.ResponseWriter, r *http.Request) {
-	s.packHandler(w, r, s.enPackTemplate)
+func (s *server) enPackHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.packHandler(w, r, s.enPackTemplate, "en")
 }
 
-func (s *server) ruPackHandler(w http.ResponseWriter, r *http.Request) {
-	s.packHandler(w, r, s.ruPackTemplate)
+func (s *server) ruPackHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.packHandler(w, r, s.ruPackTemplate, "ru")
 }
 
 func checkSirenParam(siren string) string {
@@ -312,11 +406,11 @@ func checkSirenParam(siren string) string {
 	return siren
 }
 
-func (s *server) codeHandler(w http.ResponseWriter, r *http.Request, t *ht.Template) {
-	pack := s.findPack(mux.Vars(r)["pack"])
+func (s *server) codeHandler(w http.ResponseWriter, r *http.Request, t *ht.Template) error {
+	pack, preview := s.requestedPack(w, r)
 	if pack == nil {
 		notFoundError(w)
-		return
+		return nil
 	}
 	paramDict := getParamDict(packParams, r)
 	siren := checkSirenParam(paramDict["siren"])
@@ -325,68 +419,103 @@ func (s *server) codeHandler(w http.ResponseWriter, r *http.Request, t *ht.Templ
 		_, sizeErr = strconv.Atoi(paramDict["size"])
 	}
 	if siren == "" || sizeErr != nil {
+		if siren == "" {
+			sirenValidationFailures.Inc()
+		}
 		target := "/chic/p/" + pack.Name
+		if preview == "" && isAdminPreview(r) {
+			target = "/admin/preview/" + pack.Name
+		}
 		if r.URL.RawQuery != "" {
 			target += "?" + r.URL.RawQuery
 		}
 		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
-		return
+		return nil
 	}
 	paramDict["siren"] = siren
-	code, err := s.chaturbateCode(pack, paramDict)
+	code, err := s.cachedChaturbateCode(r.Context(), pack, paramDict)
 	if err != nil {
 		notFoundError(w)
-		return
+		return nil
+	}
+	if preview == "" && !isAdminPreview(r) {
+		codeGenerations.WithLabelValues(pack.Name, placementLabel(paramDict["placement"])).Inc()
+		s.analytics.recordCodeGeneration(pack.Name, paramDict)
 	}
-	checkErr(t.Execute(w, s.tparams(r, map[string]interface{}{"pack": pack, "params": paramDict, "code": code})))
+	return render(w, r, t, s.tparams(r, map[string]interface{}{
+		"pack":    pack,
+		"params":  paramDict,
+		"code":    code,
+		"preview": preview,
+		"links":   newPackLinks(r, pack, preview),
+	}))
 }
 
-func (s *server) enCodeHandler(w http.ResponseWriter, r *http.Request) {
-	s.codeHandler(w, r, s.enCodeTemplate)
+func (s *server) enCodeHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.codeHandler(w, r, s.enCodeTemplate)
 }
 
-func (s *server) ruCodeHandler(w http.ResponseWriter, r *http.Request) {
-	s.codeHandler(w, r, s.ruCodeTemplate)
+func (s *server) ruCodeHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.codeHandler(w, r, s.ruCodeTemplate)
 }
 
-func (s *server) testHandler(w http.ResponseWriter, r *http.Request) {
-	pack := s.findPack(mux.Vars(r)["pack"])
+func (s *server) testHandler(w http.ResponseWriter, r *http.Request) error {
+	pack, _ := s.requestedPack(w, r)
 	if pack == nil {
 		notFoundError(w)
-		return
+		return nil
 	}
 	paramDict := getParamDict(packParams, r)
-	code, err := s.chaturbateCode(pack, paramDict)
+	code, err := s.cachedChaturbateCode(r.Context(), pack, paramDict)
 	if err != nil {
 		notFoundError(w)
-		return
+		return nil
 	}
-	_, _ = w.Write([]byte(code))
+	w.Header().Set("Content-Security-Policy", testPagePolicy)
+	_, err = w.Write([]byte(code))
+	return err
 }
 
-func (s *server) likeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) likeHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	if !s.siteOrigin(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "JSON expected", http.StatusUnsupportedMediaType)
+		return nil
+	}
 	pack := s.findPack(mux.Vars(r)["pack"])
 	if pack == nil {
 		notFoundError(w)
-		return
+		return nil
+	}
+	if !s.dbAvailable() {
+		http.Error(w, "likes are temporarily unavailable", http.StatusServiceUnavailable)
+		return nil
 	}
 	body, err := io.ReadAll(io.LimitReader(r.Body, 1000))
 	if err != nil {
-		notFoundError(w)
-		return
+		http.Error(w, "cannot read the request", http.StatusBadRequest)
+		return nil
 	}
 	cmdlib.CloseBody(r.Body)
 	var like likeForPack
 	if err := json.Unmarshal(body, &like); err != nil {
-		notFoundError(w)
-		return
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return nil
 	}
 	if like.Pack != pack.Name {
-		notFoundError(w)
-		return
+		http.Error(w, "pack mismatch", http.StatusBadRequest)
+		return nil
 	}
 	ip := r.Header.Get("X-Forwarded-For")
-	s.mustExec(`
+	err = s.exec(r.Context(), `
 		insert into likes (address, pack, "like", timestamp) values ($1, $2, $3, $4)
 		on conflict(address, pack) do update set "like"=excluded."like", timestamp=excluded.timestamp`,
 		ip,
@@ -394,23 +523,49 @@ func (s *server) likeHandler(w http.ResponseWriter, r *http.Request) {
 		like.Like,
 		int32(time.Now().Unix()),
 	)
+	if err != nil {
+		return err
+	}
+	vote := "dislike"
+	if like.Like {
+		vote = "like"
+	}
+	likeVotes.WithLabelValues(like.Pack, vote).Inc()
+	return nil
 }
 
-func (s *server) likes() map[string]int {
-	query := s.mustQuery(`select pack, sum(case when "like" then 1 else -1 end) from likes group by pack`)
+func (s *server) likes(ctx context.Context) (map[string]int, error) {
+	query, err := s.query(ctx, `select pack, sum(case when "like" then 1 else -1 end) from likes group by pack`)
+	if err != nil {
+		return nil, err
+	}
 	defer query.Close()
 	results := map[string]int{}
 	for query.Next() {
 		var pack string
 		var count int
-		checkErr(query.Scan(&pack, &count))
+		if err := query.Scan(&pack, &count); err != nil {
+			return nil, err
+		}
 		results[pack] = count
 	}
-	return results
+	return results, query.Err()
 }
 
+// findPack finds a pack shown to visitors
 func (s *server) findPack(name string) *sitelib.PackV2 {
-	for _, pack := range s.packs {
+	_, enabledPacks := s.packState()
+	return findPackIn(enabledPacks, name)
+}
+
+// findAnyPack finds a pack including disabled and unpublished ones
+func (s *server) findAnyPack(name string) *sitelib.PackV2 {
+	packs, _ := s.packState()
+	return findPackIn(packs, name)
+}
+
+func findPackIn(packs []sitelib.PackV2, name string) *sitelib.PackV2 {
+	for _, pack := range packs {
 		if pack.Name == name {
 			return &pack
 		}
@@ -418,24 +573,41 @@ func (s *server) findPack(name string) *sitelib.PackV2 {
 	return nil
 }
 
+func (s *server) cachedChaturbateCode(ctx context.Context, pack *sitelib.PackV2, params map[string]string) (string, error) {
+	key := newCodeCacheKey(pack, params)
+	if code, ok := s.codeCache.get(key); ok {
+		return code, nil
+	}
+	code, err := s.chaturbateCode(ctx, pack, params)
+	if err != nil {
+		return "", err
+	}
+	s.codeCache.add(key, code)
+	if s.cfg.Debug {
+		stats := s.codeCache.stats()
+		slog.Debug("code cache", "hits", stats.Hits, "misses", stats.Misses, "entries", stats.Entries, "bytes", stats.Bytes)
+	}
+	return code, nil
+}
+
 type iconSize struct {
 	Width  float64
 	Height float64
 }
 
-func (s *server) chaturbateCode(pack *sitelib.PackV2, params map[string]string) (string, error) {
-	t := parseHTMLTemplate("common/icons-code-generator.gohtml")
+func (s *server) chaturbateCode(ctx context.Context, pack *sitelib.PackV2, params map[string]string) (_ string, err error) {
+	_, span := tracer.Start(ctx, "chaturbateCode", trace.WithAttributes(attribute.String("pack", pack.Name)))
+	defer func() { endSpan(span, err) }()
 	var b bytes.Buffer
 	w := bufio.NewWriter(&b)
-	unscaledSize := 5.4
+	pxSize := 54
 	if params["size"] != "" {
-		pxSize, err := strconv.Atoi(params["size"])
-		if err != nil {
+		if pxSize, err = strconv.Atoi(params["size"]); err != nil {
 			return "", err
 		}
-		chaturbateREMToPXCoeff := 10.
-		unscaledSize = float64(pxSize) / chaturbateREMToPXCoeff
 	}
+	chaturbateREMToPXCoeff := 10.
+	unscaledSize := float64(pxSize) / chaturbateREMToPXCoeff
 	width := unscaledSize * float64(*pack.ChaturbateIconsScale) / float64(100)
 	hgap := 25
 	if pack.HGap != nil {
@@ -448,22 +620,30 @@ func (s *server) chaturbateCode(pack *sitelib.PackV2, params map[string]string)
 			Height: width * v.Height / v.Width,
 		}
 	}
-	checkErr(t.Execute(w, map[string]interface{}{
+	// PNG icons are for bios where SVG images don't show up
+	var rasterHeights map[string]int
+	if params["format"] == "png" && pack.FinalType == "svg" {
+		rasterHeights = map[string]int{}
+		for k, v := range pack.Icons {
+			rasterHeights[k] = rasterHeight(pack, v, pxSize)
+		}
+	}
+	err = s.codeGeneratorTemplate.Execute(w, map[string]interface{}{
 		"pack":               pack,
 		"params":             params,
 		"hgap":               int(width*10) * (hgap + 100 - *pack.ChaturbateIconsScale) / 100,
 		"base_url":           s.cfg.BaseURL,
 		"icon_sizes":         iconSizes,
+		"raster_heights":     rasterHeights,
 		"bio_header_remover": s.bioHeaderRemover,
-	}))
-	checkErr(w.Flush())
-	m := minify.New()
-	m.Add("text/html", &hmin.Minifier{KeepQuotes: true, KeepComments: true})
-	str, err := m.String("text/html", b.String())
+	})
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
 	}
-	return str, nil
+	return s.minifier.String("text/html", b.String())
 }
 
 func cacheControlHandler(h http.Handler, mins int) http.Handler {
@@ -473,31 +653,31 @@ func cacheControlHandler(h http.Handler, mins int) http.Handler {
 	})
 }
 
-func (s *server) measure(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		now := time.Now()
-		h.ServeHTTP(w, r)
-		elapsed := time.Since(now).Milliseconds()
-		if s.cfg.Debug {
-			ldbg("performance for %s: %dms", r.URL.Path, elapsed)
+func (s *server) votesForPack(ctx context.Context, pack string) (likes int, dislikes int, err error) {
+	rows, err := s.query(ctx, `select count(*) filter (where "like"), count(*) filter (where not "like") from likes where pack = $1`, pack)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&likes, &dislikes); err != nil {
+			return 0, 0, err
 		}
-	})
-}
-
-func (s *server) likesForPack(pack string) int {
-	return s.mustInt(`select coalesce(sum(case when "like" then 1 else -1 end), 0) from likes where pack = $1`, pack)
+	}
+	return likes, dislikes, rows.Err()
 }
 
 func (s *server) iconsCount() int {
 	count := 0
-	for _, i := range s.packs {
+	packs, _ := s.packState()
+	for _, i := range packs {
 		count += len(i.Icons)
 	}
 	return count
 }
 
 func (s *server) fillRawFiles() {
-	content, err := os.ReadFile("pages/common/bio-header-remover.html")
+	content, err := fs.ReadFile(s.assets, "pages/common/bio-header-remover.html")
 	checkErr(err)
 	s.bioHeaderRemover = strings.TrimSuffix(string(content), "\n")
 	re := regexp.MustCompile(`\n\s*`)
@@ -507,81 +687,155 @@ func (s *server) fillRawFiles() {
 	re = regexp.MustCompile(`\s+\)`)
 	s.bioHeaderRemover = re.ReplaceAllString(s.bioHeaderRemover, ")")
 
-	content, err = os.ReadFile("partial/favicons.partial.html")
+	content, err = fs.ReadFile(s.assets, "partial/favicons.partial.html")
 	checkErr(err)
 	s.partialFaviconsHTML = string(content)
 
-	content, err = os.ReadFile("static/style.css")
+	content, err = fs.ReadFile(s.assets, "static/style.css")
 	checkErr(err)
 	s.cssContent = string(content)
 }
 
 func (s *server) fillTemplates() {
 	common := []string{"common/head.gohtml", "common/header.gohtml", "common/footer.gohtml", "common/header-icon.gohtml"}
-	s.enIndexTemplate = parseHTMLTemplate(append([]string{"en/index.gohtml", "en/trans.gohtml"}, common...)...)
-	s.ruIndexTemplate = parseHTMLTemplate(append([]string{"ru/index.gohtml", "ru/trans.gohtml"}, common...)...)
-	s.enStreamerTemplate = parseHTMLTemplate(append([]string{"en/streamer.gohtml", "en/trans.gohtml"}, common...)...)
-	s.ruStreamerTemplate = parseHTMLTemplate(append([]string{"ru/streamer.gohtml", "ru/trans.gohtml"}, common...)...)
-	s.enStreamerNotificationsTemplate = parseHTMLTemplate(append([]string{"en/streamer-notifications.gohtml", "en/trans.gohtml"}, common...)...)
-	s.ruStreamerNotificationsTemplate = parseHTMLTemplate(append([]string{"ru/streamer-notifications.gohtml", "ru/trans.gohtml"}, common...)...)
-	s.enStreamerChannelTemplate = parseHTMLTemplate(append([]string{"en/streamer-channel.gohtml", "en/trans.gohtml"}, common...)...)
-	s.ruStreamerChannelTemplate = parseHTMLTemplate(append([]string{"ru/streamer-channel.gohtml", "ru/trans.gohtml"}, common...)...)
+	s.enIndexTemplate = s.parseHTMLTemplate(append([]string{"en/index.gohtml", "en/trans.gohtml"}, common...)...)
+	s.ruIndexTemplate = s.parseHTMLTemplate(append([]string{"ru/index.gohtml", "ru/trans.gohtml"}, common...)...)
+	s.enStreamerTemplate = s.parseHTMLTemplate(append([]string{"en/streamer.gohtml", "en/trans.gohtml"}, common...)...)
+	s.ruStreamerTemplate = s.parseHTMLTemplate(append([]string{"ru/streamer.gohtml", "ru/trans.gohtml"}, common...)...)
+	s.enStreamerNotificationsTemplate = s.parseHTMLTemplate(append([]string{"en/streamer-notifications.gohtml", "en/trans.gohtml"}, common...)...)
+	s.ruStreamerNotificationsTemplate = s.parseHTMLTemplate(append([]string{"ru/streamer-notifications.gohtml", "ru/trans.gohtml"}, common...)...)
+	s.enStreamerChannelTemplate = s.parseHTMLTemplate(append([]string{"en/streamer-channel.gohtml", "en/trans.gohtml"}, common...)...)
+	s.ruStreamerChannelTemplate = s.parseHTMLTemplate(append([]string{"ru/streamer-channel.gohtml", "ru/trans.gohtml"}, common...)...)
+	s.enErrorTemplate = s.parseHTMLTemplate(append([]string{"en/error.gohtml", "en/trans.gohtml"}, common...)...)
+	s.ruErrorTemplate = s.parseHTMLTemplate(append([]string{"ru/error.gohtml", "ru/trans.gohtml"}, common...)...)
+	s.adminAnalyticsTemplate = s.parseHTMLTemplate("admin/analytics.gohtml", "common/head.gohtml")
+	s.adminDashboardTemplate = s.parseHTMLTemplate("admin/dashboard.gohtml", "common/head.gohtml")
 
 	chic := []string{"common/head.gohtml", "common/header.gohtml", "common/footer.gohtml", "common/cpix.gohtml"}
-	s.enChicTemplate = parseHTMLTemplate(append([]string{"common/chic.gohtml", "en/chic.gohtml", "en/trans.gohtml"}, chic...)...)
-	s.ruChicTemplate = parseHTMLTemplate(append([]string{"common/chic.gohtml", "ru/chic.gohtml", "ru/trans.gohtml"}, chic...)...)
-	s.enPackTemplate = parseHTMLTemplate(append([]string{"en/pack.gohtml", "en/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
-	s.ruPackTemplate = parseHTMLTemplate(append([]string{"ru/pack.gohtml", "ru/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
-	s.enCodeTemplate = parseHTMLTemplate(append([]string{"en/code.gohtml", "en/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
-	s.ruCodeTemplate = parseHTMLTemplate(append([]string{"ru/code.gohtml", "ru/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
-}
-
-func (s *server) fillEnabledPacks() {
-	packs := make([]sitelib.PackV2, 0, len(s.packs))
-	for _, pack := range s.packs {
-		if !pack.Disable {
-			packs = append(packs, pack)
+	s.enChicTemplate = s.parseHTMLTemplate(append([]string{"common/chic.gohtml", "en/chic.gohtml", "en/trans.gohtml"}, chic...)...)
+	s.ruChicTemplate = s.parseHTMLTemplate(append([]string{"common/chic.gohtml", "ru/chic.gohtml", "ru/trans.gohtml"}, chic...)...)
+	s.enPackTemplate = s.parseHTMLTemplate(append([]string{"en/pack.gohtml", "en/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
+	s.ruPackTemplate = s.parseHTMLTemplate(append([]string{"ru/pack.gohtml", "ru/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
+	s.enCodeTemplate = s.parseHTMLTemplate(append([]string{"en/code.gohtml", "en/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
+	s.ruCodeTemplate = s.parseHTMLTemplate(append([]string{"ru/code.gohtml", "ru/trans.gohtml", "common/twitter.gohtml"}, chic...)...)
+
+	s.codeGeneratorTemplate = s.parseHTMLTemplate("common/icons-code-generator.gohtml")
+	s.minifier = newCodeMinifier()
+}
+
+func newCodeMinifier() *minify.M {
+	m := minify.New()
+	m.Add("text/html", &hmin.Minifier{KeepQuotes: true, KeepComments: true})
+	return m
+}
+
+func valueOr[T comparable](value, def T) T {
+	var zero T
+	if value == zero {
+		return def
+	}
+	return value
+}
+
+func (s *server) newHTTPServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadTimeout:       valueOr(s.cfg.ReadTimeout, 30*time.Second),
+		ReadHeaderTimeout: valueOr(s.cfg.ReadHeaderTimeout, 10*time.Second),
+		WriteTimeout:      valueOr(s.cfg.WriteTimeout, 60*time.Second),
+		IdleTimeout:       valueOr(s.cfg.IdleTimeout, 120*time.Second),
+		MaxHeaderBytes:    valueOr(s.cfg.MaxHeaderBytes, 64<<10),
+	}
+}
+
+// listener pairs a socket with the handler serving it
+type listener struct {
+	ln      net.Listener
+	handler http.Handler
+}
+
+// serve serves requests until SIGINT or SIGTERM,
+// then drains in-flight requests
+func (s *server) serve(listeners ...listener) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, len(listeners))
+	var httpServers []*http.Server
+	for _, l := range listeners {
+		httpServer := s.newHTTPServer(l.handler)
+		httpServers = append(httpServers, httpServer)
+		go func() { serveErr <- httpServer.Serve(l.ln) }()
+	}
+	select {
+	case err := <-serveErr:
+		checkErr(err)
+	case <-ctx.Done():
+	}
+	stop()
+	shutdownTimeout := valueOr(s.cfg.ShutdownTimeout, 20*time.Second)
+	slog.Info("shutting down, waiting for in-flight requests...", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("could not drain connections", "error", err)
+			_ = httpServer.Close()
 		}
 	}
-	s.enabledPacks = packs
 }
 
 func (s *server) logConfig() {
-	cfgString, err := json.MarshalIndent(s.cfg, "", "    ")
+	cfgString, err := json.Marshal(s.cfg)
 	checkErr(err)
-	linf("site config: " + string(cfgString))
+	slog.Info("site config", "config", string(cfgString))
 }
 
 func main() {
-	linf("starting...")
 	srv := &server{cfg: sitelib.ReadConfig()}
+	slog.SetDefault(newLogger(srv.cfg))
+	slog.Info("starting...", "version", cmdlib.Version)
+	shutdownTracing, err := setupTracing(context.Background(), srv.cfg)
+	checkErr(err)
 	srv.logConfig()
-	srv.packs = sitelib.ParsePacksV2(srv.cfg)
-	for _, pack := range srv.packs {
-		if pack.ChaturbateIconsScale == nil {
-			panic(fmt.Sprintf("pack %s has no chaturbate_icons_scale", pack.Name))
-		}
-	}
-	if len(srv.packs) > 2 {
-		srv.packs = append([]sitelib.PackV2{srv.packs[len(srv.packs)-1]}, srv.packs[:len(srv.packs)-1]...)
+	srv.assets = openAssets(srv.cfg.AssetsDir)
+	srv.codeCache = newCodeCache(srv.cfg.CodeCacheMaxEntries, srv.cfg.CodeCacheMaxBytes)
+	srv.iconCache = newIconCache(srv.cfg.IconCacheMaxBytes)
+	bucket, err := sitelib.NewBucketClient(context.Background(), srv.cfg)
+	checkErr(err)
+	srv.bucket = bucket
+	srv.analytics = newAnalyticsRecorder()
+	srv.registerStateMetrics()
+	srv.configHash = configHash(srv.cfg)
+	checkErr(validateRedirects(srv.redirects()))
+	srv.trustedProxies, err = parseTrustedProxies(srv.cfg.TrustedProxies)
+	checkErr(err)
+	if len(srv.trustedProxies) == 0 && strings.HasPrefix(srv.cfg.BaseURL, "https://") {
+		slog.Warn("no trusted proxies, HTTPS is not enforced")
 	}
+	packs := sitelib.ParsePacksV2(srv.cfg)
+	checkErr(validatePacks(packs))
 	srv.fillRawFiles()
 	srv.fillTemplates()
-	srv.fillEnabledPacks()
-	db, err := pgxpool.New(context.Background(), string(srv.cfg.ConnectionString))
-	checkErr(err)
-	srv.db = db
-	srv.createDatabase()
-	fmt.Printf("%d packs loaded, %d icons\n", len(srv.packs), srv.iconsCount())
+	srv.setPacks(packs)
+	srv.dbDone = make(chan struct{})
+	dbCtx, stopDB := context.WithCancel(context.Background())
+	go srv.maintainDatabase(dbCtx)
+	analyticsCtx, stopAnalytics := context.WithCancel(context.Background())
+	go srv.runAnalytics(analyticsCtx)
+	scheduleCtx, stopSchedule := context.WithCancel(context.Background())
+	go srv.schedulePacks(scheduleCtx)
+	slog.Info("packs loaded", "packs", len(packs), "icons", srv.iconsCount())
 	ruDomain := "ru." + srv.cfg.BaseDomain
 	r := mux.NewRouter().StrictSlash(true)
 
-	bilingualRoute := func(path string, ruHandler, enHandler http.HandlerFunc) {
+	bilingualRoute := func(path string, ruHandler, enHandler handlerFunc) {
+		srv.localizedRoutes = append(srv.localizedRoutes, path)
+		ru := srv.handle(path, "ru", ruHandler)
 		if srv.cfg.Lang == "ru" {
-			r.Handle(path, srv.measure(handlers.CompressHandler(ruHandler)))
+			r.Handle(path, srv.measure(handlers.CompressHandler(ru)))
 		} else {
-			r.Handle(path, srv.measure(handlers.CompressHandler(ruHandler))).Host(ruDomain)
-			r.Handle(path, srv.measure(handlers.CompressHandler(enHandler)))
+			r.Handle(path, srv.measure(handlers.CompressHandler(ru))).Host(ruDomain)
+			r.Handle(path, srv.measure(handlers.CompressHandler(srv.handle(path, "en", enHandler))))
 		}
 	}
 
@@ -592,20 +846,56 @@ func main() {
 	bilingualRoute("/chic", srv.ruChicHandler, srv.enChicHandler)
 	bilingualRoute("/chic/p/{pack}", srv.ruPackHandler, srv.enPackHandler)
 	bilingualRoute("/chic/code/{pack}", srv.ruCodeHandler, srv.enCodeHandler)
-	r.Handle("/chic/test/{pack}", srv.measure(handlers.CompressHandler(http.HandlerFunc(srv.testHandler))))
-	r.Handle("/chic/like/{pack}", srv.measure(http.HandlerFunc(srv.likeHandler)))
-
-	r.PathPrefix("/icons/").Handler(http.StripPrefix("/icons", cacheControlHandler(http.FileServer(http.Dir("icons")), 120)))
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static", cacheControlHandler(handlers.CompressHandler(http.FileServer(http.Dir("static"))), 120)))
-
-	r.Handle("/ru", newRedirectSubdHandler("ru", "", http.StatusMovedPermanently))
-	r.Handle("/ru.html", newRedirectSubdHandler("ru", "", http.StatusMovedPermanently))
-	r.Handle("/streamer-ru", newRedirectSubdHandler("ru", "/streamer", http.StatusMovedPermanently))
-	r.Handle("/model.html", http.RedirectHandler("/streamer", http.StatusMovedPermanently))
-	r.Handle("/model-ru.html", newRedirectSubdHandler("ru", "/streamer", http.StatusMovedPermanently))
+	r.Handle("/chic/i/{pack}/{file}", srv.measure(srv.handle("/chic/i/{pack}/{file}", srv.cfg.Lang, srv.iconHandler))).Methods("GET", "HEAD")
+	r.Handle("/chic/banner/{pack}/{size}.jpg", srv.measure(srv.handle("/chic/banner/{pack}/{size}.jpg", srv.cfg.Lang, srv.bannerHandler))).Methods("GET", "HEAD")
+	r.Handle("/chic/download/{pack}.zip", srv.measure(srv.handle("/chic/download/{pack}.zip", srv.cfg.Lang, srv.downloadHandler))).Methods("GET", "HEAD")
+	r.Handle("/chic/test/{pack}", srv.measure(handlers.CompressHandler(srv.handle("/chic/test/{pack}", srv.cfg.Lang, srv.testHandler))))
+	r.Handle("/chic/like/{pack}", srv.measure(srv.handle("/chic/like/{pack}", srv.cfg.Lang, srv.likeHandler)))
+	r.Handle("/sitemap.xml", srv.measure(handlers.CompressHandler(srv.handle("/sitemap.xml", srv.cfg.Lang, srv.sitemapHandler))))
+	r.Handle("/robots.txt", srv.measure(srv.handle("/robots.txt", srv.cfg.Lang, srv.robotsHandler)))
+	r.Handle("/healthz", http.HandlerFunc(srv.healthzHandler))
+	r.Handle("/readyz", http.HandlerFunc(srv.readyzHandler))
+	r.Handle("/version", http.HandlerFunc(srv.versionHandler))
+	adminRoute := func(path string, h handlerFunc) http.Handler {
+		return srv.measure(srv.adminAuth(srv.handle(path, "en", h)))
+	}
+	r.Handle("/admin", adminRoute("/admin", srv.adminDashboardHandler)).Methods("GET")
+	r.Handle("/admin/analytics", adminRoute("/admin/analytics", srv.adminAnalyticsHandler)).Methods("GET")
+	r.Handle("/admin/preview/{pack}", adminRoute("/admin/preview/{pack}", adminPreview(srv.adminPreviewHandler))).Methods("GET")
+	r.Handle("/admin/code/{pack}", adminRoute("/admin/code/{pack}", adminPreview(srv.enCodeHandler))).Methods("GET")
+	r.Handle("/admin/download/{pack}.zip", adminRoute("/admin/download/{pack}.zip", adminPreview(srv.downloadHandler))).Methods("GET", "HEAD")
+	r.Handle("/admin/reload", adminRoute("/admin/reload", srv.adminReloadHandler)).Methods("POST")
+	r.Handle("/admin/packs/{pack}/{action:disable|enable|pin|unpin|rename}", adminRoute("/admin/packs/{pack}/{action}", srv.adminPackHandler)).Methods("POST")
+
+	r.PathPrefix("/icons/").Handler(http.StripPrefix("/icons", cacheControlHandler(http.FileServer(subAssets(srv.assets, "icons")), 120)))
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static", cacheControlHandler(handlers.CompressHandler(http.FileServer(subAssets(srv.assets, "static"))), 120)))
+
+	for _, rule := range srv.redirects() {
+		r.Handle(rule.Source, newRedirectSubdHandler(srv.cfg.BaseDomain, rule))
+	}
 
 	ln, err := net.Listen("tcp", srv.cfg.ListenAddress)
 	checkErr(err)
-	linf("listening on %s", ln.Addr())
-	checkErr(http.Serve(ln, r))
+	slog.Info("listening", "address", ln.Addr().String())
+	listeners := []listener{{ln: ln, handler: requestIDHandler(srv.canonicalHostHandler(srv.securityHeaders(r)))}}
+	if srv.cfg.MetricsListenAddress != "" {
+		metricsLn, err := net.Listen("tcp", srv.cfg.MetricsListenAddress)
+		checkErr(err)
+		slog.Info("serving metrics", "address", metricsLn.Addr().String())
+		listeners = append(listeners, listener{ln: metricsLn, handler: metricsHandler()})
+	} else {
+		r.Handle("/metrics", metricsHandler())
+	}
+	srv.serve(listeners...)
+	stopSchedule()
+	stopAnalytics()
+	<-srv.analytics.done
+	stopDB()
+	<-srv.dbDone
+	tracingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("could not flush traces", "error", err)
+	}
+	slog.Info("stopped")
 }
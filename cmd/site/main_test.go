@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
+)
+
+func benchmarkPack() *sitelib.PackV2 {
+	scale := 90
+	icons := map[string]sitelib.IconV2{}
+	for _, p := range packParams {
+		icons[p] = sitelib.IconV2{Version: 2, Width: 100, Height: 120}
+	}
+	return &sitelib.PackV2{
+		HumanName:            "Benchmark",
+		Scale:                100,
+		ChaturbateIconsScale: &scale,
+		FinalType:            "svg",
+		Revision:             1,
+		Icons:                icons,
+		Name:                 "benchmark",
+	}
+}
+
+func BenchmarkChaturbateCode(b *testing.B) {
+	s := &server{cfg: &sitelib.Config{BaseURL: "https://siren.chat"}, assets: embeddedAssets}
+	s.codeGeneratorTemplate = s.parseHTMLTemplate("common/icons-code-generator.gohtml")
+	s.minifier = newCodeMinifier()
+	pack := benchmarkPack()
+	params := map[string]string{}
+	for _, p := range packParams {
+		params[p] = "https://example.com/" + p
+	}
+	params["siren"] = "username"
+	params["fanclub"] = "on"
+	params["placement"] = "inline"
+	params["size"] = "54"
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.chaturbateCode(context.Background(), pack, params); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLikeHandler(t *testing.T) {
+	s := &server{cfg: &sitelib.Config{BaseURL: "https://siren.chat", BaseDomain: "siren.chat"}}
+	s.codeCache = newCodeCache(0, 0)
+	s.setPacks([]sitelib.PackV2{*benchmarkPack()})
+	s.setDBState(dbDisabled)
+	for _, c := range []struct {
+		name        string
+		method      string
+		site        string
+		origin      string
+		contentType string
+		status      int
+	}{
+		{"get", http.MethodGet, "same-origin", "https://siren.chat", "application/json", http.StatusMethodNotAllowed},
+		{"cross site", http.MethodPost, "cross-site", "https://siren.chat", "application/json", http.StatusForbidden},
+		{"no origin", http.MethodPost, "same-origin", "", "application/json", http.StatusForbidden},
+		{"foreign origin", http.MethodPost, "", "https://example.com", "application/json", http.StatusForbidden},
+		{"lookalike origin", http.MethodPost, "", "https://siren.chat.example.com", "application/json", http.StatusForbidden},
+		{"text", http.MethodPost, "same-origin", "https://siren.chat", "text/plain", http.StatusUnsupportedMediaType},
+		{"form", http.MethodPost, "same-origin", "https://siren.chat", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		// accepted requests fail only because the database is disabled
+		{"same origin", http.MethodPost, "same-origin", "https://siren.chat", "application/json", http.StatusServiceUnavailable},
+		{"locale subdomain", http.MethodPost, "same-site", "https://ru.siren.chat", "application/json; charset=utf-8", http.StatusServiceUnavailable},
+	} {
+		req := httptest.NewRequest(c.method, "https://siren.chat/chic/like/benchmark", strings.NewReader(`{"pack":"benchmark","like":true}`))
+		req = mux.SetURLVars(req, map[string]string{"pack": "benchmark"})
+		if c.site != "" {
+			req.Header.Set("Sec-Fetch-Site", c.site)
+		}
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		req.Header.Set("Content-Type", c.contentType)
+		rec := httptest.NewRecorder()
+		if err := s.likeHandler(rec, req); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "siren_site_http_request_duration_seconds",
+		Help:    "HTTP request duration by route template and status",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "status"})
+
+	codeGenerations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "siren_site_code_generations_total",
+		Help: "Generated code pages by pack and placement",
+	}, []string{"pack", "placement"})
+
+	likeVotes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "siren_site_like_votes_total",
+		Help: "Like votes by pack and vote",
+	}, []string{"pack", "vote"})
+
+	sirenValidationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "siren_site_siren_validation_failures_total",
+		Help: "Invalid Chaturbate usernames submitted to the constructor",
+	})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "siren_site_db_query_duration_seconds",
+		Help:    "Database query duration by operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	codeCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "siren_site_code_cache_requests_total",
+		Help: "Generated code cache lookups by result",
+	}, []string{"result"})
+
+	iconCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "siren_site_icon_cache_requests_total",
+		Help: "Icon cache lookups by result",
+	}, []string{"result"})
+
+	iconRenders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "siren_site_icon_renders_total",
+		Help: "SVG icons rendered to raster formats by format",
+	}, []string{"format"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestDuration,
+		codeGenerations,
+		likeVotes,
+		sirenValidationFailures,
+		dbQueryDuration,
+		codeCacheRequests,
+		iconCacheRequests,
+		iconRenders,
+	)
+}
+
+func (s *server) registerStateMetrics() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "siren_site_database_up",
+			Help: "Whether the database is available",
+		}, func() float64 {
+			if s.dbAvailable() {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "siren_site_code_cache_entries",
+			Help: "Entries in the generated code cache",
+		}, func() float64 { return float64(s.codeCache.stats().Entries) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "siren_site_code_cache_bytes",
+			Help: "Size of the generated code cache",
+		}, func() float64 { return float64(s.codeCache.stats().Bytes) }),
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// placementLabel keeps the label cardinality bounded for arbitrary query values
+func placementLabel(placement string) string {
+	switch placement {
+	case "header", "inline":
+		return placement
+	case "":
+		return "none"
+	}
+	return "other"
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			return t
+		}
+	}
+	return "unknown"
+}
+
+func observeDBQuery(operation string, start time.Time) {
+	dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (s *server) measure(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		ctx := r.Context()
+		if s.fromTrustedProxy(r) {
+			// a client could force sampling with its own traceparent
+			ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", r.URL.Path),
+		))
+		defer span.End()
+		r = r.WithContext(ctx)
+		m := httpsnoop.CaptureMetrics(h, w, r)
+		span.SetAttributes(attribute.Int("http.response.status_code", m.Code))
+		if m.Code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(m.Code))
+		}
+		requestDuration.WithLabelValues(route, strconv.Itoa(m.Code)).Observe(m.Duration.Seconds())
+		logAccess(r, route, s.requestLang(r), m.Code, m.Written, m.Duration.Milliseconds())
+	})
+}
@@ -2,43 +2,71 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 
 	"github.com/jackc/pgx/v5"
 )
 
-var migrations = []func(s *server){
-	func(s *server) {
-		s.mustExec(`create table likes (
+var migrations = []func(ctx context.Context, s *server) error{
+	func(ctx context.Context, s *server) error {
+		return s.exec(ctx, `create table likes (
 			address text,
 			pack text,
 			"like" boolean not null default false,
 			primary key (address, pack));`)
 	},
-	func(s *server) {
-		s.mustExec("alter table likes add timestamp integer not null default 0;")
+	func(ctx context.Context, s *server) error {
+		return s.exec(ctx, "alter table likes add timestamp integer not null default 0;")
+	},
+	func(ctx context.Context, s *server) error {
+		return s.exec(ctx, `create table code_generations (
+			day date not null,
+			pack text not null,
+			placement text not null,
+			size integer not null,
+			networks text not null,
+			count integer not null default 0,
+			primary key (day, pack, placement, size, networks));`)
+	},
+	func(ctx context.Context, s *server) error {
+		return s.exec(ctx, `create table pack_overrides (
+			pack text primary key,
+			disable boolean not null default false,
+			pinned boolean not null default false,
+			human_name text not null default '');`)
 	},
 }
 
-func (s *server) applyMigrations() {
-	row := s.db.QueryRow(context.Background(), "select version from schema_version")
+func (s *server) applyMigrations(ctx context.Context) error {
+	row := s.db.QueryRow(ctx, "select version from schema_version")
 	var version int
 	err := row.Scan(&version)
-	if err == pgx.ErrNoRows {
+	if errors.Is(err, pgx.ErrNoRows) {
 		version = -1
-		s.mustExec("insert into schema_version(version) values (-1)")
-	} else {
-		checkErr(err)
+		if err := s.exec(ctx, "insert into schema_version(version) values (-1)"); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
 	}
 	for i, m := range migrations[version+1:] {
 		n := i + version + 1
-		linf("applying migration %d", n)
-		m(s)
-		s.mustExec("update schema_version set version = $1", n)
+		slog.Info("applying migration", "version", n)
+		if err := m(ctx, s); err != nil {
+			return err
+		}
+		if err := s.exec(ctx, "update schema_version set version = $1", n); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (s *server) createDatabase() {
-	linf("creating database if needed...")
-	s.mustExec(`create table if not exists schema_version (version integer);`)
-	s.applyMigrations()
+func (s *server) createDatabase(ctx context.Context) error {
+	slog.Info("creating database if needed...")
+	if err := s.exec(ctx, `create table if not exists schema_version (version integer);`); err != nil {
+		return err
+	}
+	return s.applyMigrations(ctx)
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aohorodnyk/mimeheader"
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
+)
+
+type iconFormat struct {
+	ext         string // extension in the bucket
+	contentType string
+	gzipped     bool
+}
+
+var (
+	avifFormat = iconFormat{ext: "avif", contentType: "image/avif"}
+	webpFormat = iconFormat{ext: "webp", contentType: "image/webp"}
+	pngFormat  = iconFormat{ext: "png", contentType: "image/png"}
+	svgzFormat = iconFormat{ext: "svgz", contentType: "image/svg+xml", gzipped: true}
+	svgFormat  = iconFormat{ext: "svg", contentType: "image/svg+xml"}
+)
+
+var iconFileRegex = regexp.MustCompile(`^(.+?)(?:\.v(\d+))?\.(png|svg)$`)
+
+// parseIconFile parses file names like siren.v2.png
+func parseIconFile(file string) (name string, version int, ext string, ok bool) {
+	m := iconFileRegex.FindStringSubmatch(file)
+	if m == nil {
+		return "", 0, "", false
+	}
+	if m[2] != "" {
+		var err error
+		if version, err = strconv.Atoi(m[2]); err != nil {
+			return "", 0, "", false
+		}
+	}
+	return m[1], version, m[3], true
+}
+
+func iconURL(pack *sitelib.PackV2, name string, ext string) string {
+	return "/chic/i/" + pack.Name + "/" + pack.VersionedIcon(name) + "." + ext
+}
+
+// acceptsExplicitly ignores wildcards,
+// clients that don't support newer formats still send */*
+// and should get the format from the URL
+func acceptsExplicitly(accept mimeheader.AcceptHeader, mtype string) bool {
+	for _, h := range accept.MHeaders {
+		if h.Quality > 0 && h.Type+"/"+h.Subtype == mtype {
+			return true
+		}
+	}
+	return false
+}
+
+// iconFormats returns formats to look for in the order of preference
+func iconFormats(ext string, accept mimeheader.AcceptHeader) []iconFormat {
+	var formats []iconFormat
+	if ext == "svg" {
+		formats = append(formats, svgzFormat, svgFormat)
+	}
+	if acceptsExplicitly(accept, "image/avif") {
+		formats = append(formats, avifFormat)
+	}
+	if acceptsExplicitly(accept, "image/webp") {
+		formats = append(formats, webpFormat)
+	}
+	formats = append(formats, pngFormat)
+	if ext == "png" {
+		// old pack pages requested svgz from clients without WebP support,
+		// so some packs might have no PNG objects
+		formats = append(formats, svgzFormat)
+	}
+	return formats
+}
+
+// packIcon returns nil if the bucket has no icon in this format
+func (s *server) packIcon(ctx context.Context, pack *sitelib.PackV2, name string, format iconFormat) ([]byte, error) {
+	return s.fetchIcon(ctx, pack.Name+"/"+pack.VersionedIcon(name)+"."+format.ext)
+}
+
+// fetchIcon is a sitelib.IconFetcher caching icons in memory
+func (s *server) fetchIcon(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := s.iconCache.get(key); ok {
+		return data, nil
+	}
+	data, err := sitelib.Download(ctx, s.bucket, s.cfg, key)
+	if sitelib.IsNotFound(err) {
+		s.iconCache.addMissing(key)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.iconCache.add(key, data)
+	return data, nil
+}
+
+// iconHandler serves pack icons in the best format the client accepts.
+// Icons of disabled packs are still served as profiles keep using them.
+func (s *server) iconHandler(w http.ResponseWriter, r *http.Request) error {
+	pack := s.findAnyPack(mux.Vars(r)["pack"])
+	name, version, ext, ok := parseIconFile(mux.Vars(r)["file"])
+	if pack == nil || !ok {
+		notFoundError(w)
+		return nil
+	}
+	icon, found := pack.Icons[name]
+	if !found || (ext == "svg" && pack.FinalType != "svg") {
+		notFoundError(w)
+		return nil
+	}
+	if version != icon.Version {
+		http.Redirect(w, r, iconURL(pack, name, ext), http.StatusFound)
+		return nil
+	}
+	accept := mimeheader.ParseAcceptHeader(r.Header.Get("Accept"))
+	if ext == "png" && pack.FinalType == "svg" {
+		return s.writeRenderedIcon(w, r, pack, name, icon, accept)
+	}
+	for _, format := range iconFormats(ext, accept) {
+		data, err := s.packIcon(r.Context(), pack, name, format)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			return writeIcon(w, r, format, data, version != 0)
+		}
+	}
+	notFoundError(w)
+	return nil
+}
+
+// writeRenderedIcon serves the SVG icon rendered at the height from the h parameter
+func (s *server) writeRenderedIcon(
+	w http.ResponseWriter,
+	r *http.Request,
+	pack *sitelib.PackV2,
+	name string,
+	icon sitelib.IconV2,
+	accept mimeheader.AcceptHeader,
+) error {
+	height, err := renderHeight(r.URL.Query().Get("h"), pack, icon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	format := pngFormat
+	if acceptsExplicitly(accept, "image/webp") {
+		format = webpFormat
+	}
+	data, err := s.renderedIcon(r.Context(), pack, name, height, format)
+	if errors.Is(err, fs.ErrNotExist) {
+		notFoundError(w)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return writeIcon(w, r, format, data, icon.Version != 0)
+}
+
+func writeIcon(w http.ResponseWriter, r *http.Request, format iconFormat, data []byte, immutable bool) error {
+	h := w.Header()
+	h.Set("Content-Type", format.contentType)
+	h.Add("Vary", "Accept")
+	if immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "public, max-age=3600")
+	}
+	if format.gzipped {
+		h.Add("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			h.Set("Content-Encoding", "gzip")
+		} else {
+			var err error
+			if data, err = sitelib.Gunzip(data); err != nil {
+				return err
+			}
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err := w.Write(data)
+	return err
+}
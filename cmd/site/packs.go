@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+// packState returns all loaded packs and the packs shown to visitors.
+// Returned slices are never modified, they are replaced on reload.
+func (s *server) packState() (packs []sitelib.PackV2, enabledPacks []sitelib.PackV2) {
+	s.packsMu.RLock()
+	defer s.packsMu.RUnlock()
+	return s.packs, s.enabledPacks
+}
+
+func (s *server) packsRefreshedAt() time.Time {
+	s.packsMu.RLock()
+	defer s.packsMu.RUnlock()
+	return s.packsLoadedAt
+}
+
+// packOverride holds runtime changes of a pack stored in the database
+type packOverride struct {
+	Disable   bool
+	Pinned    bool
+	HumanName string
+}
+
+func (s *server) setPacks(packs []sitelib.PackV2) {
+	s.packsMu.Lock()
+	s.manifestPacks = packs
+	s.packsLoadedAt = time.Now()
+	s.packsMu.Unlock()
+	s.fillEnabledPacks()
+}
+
+func (s *server) setPackOverrides(overrides map[string]packOverride) {
+	s.packsMu.Lock()
+	s.packOverrides = overrides
+	s.packsMu.Unlock()
+	s.fillEnabledPacks()
+}
+
+// manifestDisabled reports whether the pack is disabled in its own config regardless of overrides
+func (s *server) manifestDisabled(name string) bool {
+	s.packsMu.RLock()
+	defer s.packsMu.RUnlock()
+	pack := findPackIn(s.manifestPacks, name)
+	return pack != nil && pack.Disable
+}
+
+func (s *server) packOverride(name string) packOverride {
+	s.packsMu.RLock()
+	defer s.packsMu.RUnlock()
+	return s.packOverrides[name]
+}
+
+// fillEnabledPacks merges overrides over the manifest data
+// and puts the featured pack first followed by pinned packs
+func (s *server) fillEnabledPacks() {
+	s.packsMu.Lock()
+	defer s.packsMu.Unlock()
+	featured := s.cfg.FeaturedPack
+	if featured == "" && len(s.manifestPacks) > 0 {
+		featured = s.manifestPacks[len(s.manifestPacks)-1].Name
+	}
+	var first, pinned, rest []sitelib.PackV2
+	for _, pack := range s.manifestPacks {
+		override := s.packOverrides[pack.Name]
+		if override.HumanName != "" {
+			pack.HumanName = override.HumanName
+		}
+		pack.Disable = pack.Disable || override.Disable
+		switch {
+		case pack.Name == featured:
+			first = append(first, pack)
+		case override.Pinned:
+			pinned = append(pinned, pack)
+		default:
+			rest = append(rest, pack)
+		}
+	}
+	packs := append(append(first, pinned...), rest...)
+	now := time.Now()
+	enabledPacks := make([]sitelib.PackV2, 0, len(packs))
+	for _, pack := range packs {
+		if !pack.Disable && pack.Published(now) {
+			enabledPacks = append(enabledPacks, pack)
+		}
+	}
+	s.packs = packs
+	s.enabledPacks = enabledPacks
+	s.codeCache.prune(s.packs)
+}
+
+func (s *server) loadPackOverrides(ctx context.Context) (map[string]packOverride, error) {
+	rows, err := s.query(ctx, "select pack, disable, pinned, human_name from pack_overrides")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := map[string]packOverride{}
+	for rows.Next() {
+		var pack string
+		var override packOverride
+		if err := rows.Scan(&pack, &override.Disable, &override.Pinned, &override.HumanName); err != nil {
+			return nil, err
+		}
+		overrides[pack] = override
+	}
+	return overrides, rows.Err()
+}
+
+// refreshPackOverrides keeps the current overrides if the database fails,
+// the next ping retries
+func (s *server) refreshPackOverrides(ctx context.Context) {
+	overrides, err := s.loadPackOverrides(ctx)
+	if err != nil {
+		slog.Error("cannot load pack overrides", "error", err)
+		return
+	}
+	s.setPackOverrides(overrides)
+}
+
+func (s *server) savePackOverride(ctx context.Context, name string, override packOverride) error {
+	err := s.exec(ctx, `
+		insert into pack_overrides (pack, disable, pinned, human_name) values ($1, $2, $3, $4)
+		on conflict(pack) do update set disable=excluded.disable, pinned=excluded.pinned, human_name=excluded.human_name`,
+		name, override.Disable, override.Pinned, override.HumanName)
+	if err != nil {
+		return err
+	}
+	s.packsMu.Lock()
+	overrides := make(map[string]packOverride, len(s.packOverrides)+1)
+	for k, v := range s.packOverrides {
+		overrides[k] = v
+	}
+	overrides[name] = override
+	s.packOverrides = overrides
+	s.packsMu.Unlock()
+	s.fillEnabledPacks()
+	return nil
+}
+
+const packScheduleInterval = time.Minute
+
+// nextPackTransition returns the nearest future moment when a pack gets published or unpublished
+func (s *server) nextPackTransition(now time.Time) time.Time {
+	s.packsMu.RLock()
+	defer s.packsMu.RUnlock()
+	var next time.Time
+	for _, pack := range s.manifestPacks {
+		for _, at := range []int64{pack.PublishAt, pack.UnpublishAt} {
+			t := time.Unix(at, 0)
+			if at != 0 && t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next
+}
+
+// schedulePacks re-evaluates published packs until ctx is done.
+// It wakes up at the next scheduled moment and at least every packScheduleInterval
+// as reloaded packs can bring new schedules.
+func (s *server) schedulePacks(ctx context.Context) {
+	for {
+		wait := packScheduleInterval
+		if next := s.nextPackTransition(time.Now()); !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+			s.fillEnabledPacks()
+		}
+	}
+}
+
+// validatePacks checks for problems that make a pack unusable
+func validatePacks(packs []sitelib.PackV2) error {
+	for _, pack := range packs {
+		if pack.ChaturbateIconsScale == nil {
+			return fmt.Errorf("pack %s has no chaturbate_icons_scale", pack.Name)
+		}
+	}
+	return nil
+}
+
+// packWarnings lists problems of the pack that don't prevent it from working
+func packWarnings(pack *sitelib.PackV2) []string {
+	var warnings []string
+	if len(pack.Icons) == 0 {
+		warnings = append(warnings, "no icons")
+	}
+	if _, ok := pack.Icons["siren"]; !ok {
+		warnings = append(warnings, "no siren icon")
+	}
+	for name, icon := range pack.Icons {
+		if icon.Width <= 0 || icon.Height <= 0 {
+			warnings = append(warnings, "icon "+name+" has no size")
+		}
+	}
+	if pack.FinalType == "" {
+		warnings = append(warnings, "no final type")
+	}
+	return warnings
+}
+
+// reloadPacks loads packs from the bucket and replaces the current ones
+// if all of them are valid
+func (s *server) reloadPacks(ctx context.Context) error {
+	packs, err := sitelib.LoadPacksV2(ctx, s.cfg)
+	if err != nil {
+		return err
+	}
+	if err := validatePacks(packs); err != nil {
+		return err
+	}
+	s.setPacks(packs)
+	slog.Info("packs reloaded", "packs", len(packs), "icons", s.iconsCount())
+	return nil
+}
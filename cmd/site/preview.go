@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPreviewTTL = 7 * 24 * time.Hour
+	adminPreviewTTL   = time.Hour
+)
+
+type adminPreviewKey struct{}
+
+// adminPreview marks admin pages showing hidden packs to admins,
+// they are used when preview tokens are disabled
+func adminPreview(h handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return h(w, r.WithContext(context.WithValue(r.Context(), adminPreviewKey{}, true)))
+	}
+}
+
+func isAdminPreview(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminPreviewKey{}).(bool)
+	return admin
+}
+
+// packLinks are URLs of the pack pages keeping the pack visible from page to page
+type packLinks struct {
+	Pack     string
+	Code     string
+	Download string
+}
+
+func newPackLinks(r *http.Request, pack *sitelib.PackV2, preview string) packLinks {
+	if preview == "" && isAdminPreview(r) {
+		return packLinks{
+			Pack:     "/admin/preview/" + pack.Name,
+			Code:     "/admin/code/" + pack.Name,
+			Download: "/admin/download/" + pack.Name + ".zip",
+		}
+	}
+	query := ""
+	if preview != "" {
+		query = "?" + url.Values{"preview": {preview}}.Encode()
+	}
+	return packLinks{
+		Pack:     "/chic/p/" + pack.Name + query,
+		Code:     "/chic/code/" + pack.Name,
+		Download: "/chic/download/" + pack.Name + ".zip" + query,
+	}
+}
+
+func (s *server) previewSignature(pack string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.PreviewSecret))
+	mac.Write([]byte("preview\n" + pack + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// previewToken returns a token showing the pack to anyone until it expires
+func (s *server) previewToken(pack string, expires time.Time) string {
+	return strconv.FormatInt(expires.Unix(), 10) + "." + s.previewSignature(pack, expires.Unix())
+}
+
+func (s *server) validPreviewToken(pack string, token string, now time.Time) bool {
+	if s.cfg.PreviewSecret == "" {
+		return false
+	}
+	expiresStr, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.previewSignature(pack, expires)))
+}
+
+// previewURL returns a shareable link to the pack page, empty if previews are disabled
+func (s *server) previewURL(pack string) string {
+	if s.cfg.PreviewSecret == "" {
+		return ""
+	}
+	token := s.previewToken(pack, time.Now().Add(valueOr(s.cfg.PreviewTTL, defaultPreviewTTL)))
+	return s.cfg.BaseURL + "/chic/p/" + pack + "?" + url.Values{"preview": {token}}.Encode()
+}
+
+// requestedPack finds the pack of the request.
+// Packs hidden from visitors are only found with a valid preview token,
+// which is returned to be passed on to the next pages.
+func (s *server) requestedPack(w http.ResponseWriter, r *http.Request) (*sitelib.PackV2, string) {
+	name := mux.Vars(r)["pack"]
+	if isAdminPreview(r) {
+		w.Header().Set("X-Robots-Tag", "noindex")
+		return s.findAnyPack(name), ""
+	}
+	if pack := s.findPack(name); pack != nil {
+		return pack, ""
+	}
+	token := r.URL.Query().Get("preview")
+	if token == "" || !s.validPreviewToken(name, token, time.Now()) {
+		return nil, ""
+	}
+	pack := s.findAnyPack(name)
+	if pack == nil {
+		return nil, ""
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Cache-Control", "private, no-store")
+	return pack, token
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+func TestValidPreviewToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := &server{cfg: &sitelib.Config{PreviewSecret: "secret"}}
+	valid := s.previewToken("neon", now.Add(time.Hour))
+	expired := s.previewToken("neon", now.Add(-time.Second))
+	tampered := valid[:len(valid)-1] + "A"
+	if tampered == valid {
+		tampered = valid[:len(valid)-1] + "B"
+	}
+	for _, c := range []struct {
+		name   string
+		secret sitelib.Secret
+		pack   string
+		token  string
+		valid  bool
+	}{
+		{"valid", "secret", "neon", valid, true},
+		{"expired", "secret", "neon", expired, false},
+		{"expires now", "secret", "neon", s.previewToken("neon", now), false},
+		{"wrong pack", "secret", "other", valid, false},
+		{"tampered signature", "secret", "neon", tampered, false},
+		{"extended expiry", "secret", "neon", "1800000000" + valid[len("1700003600"):], false},
+		{"other secret", "other", "neon", valid, false},
+		{"empty secret", "", "neon", valid, false},
+		{"empty", "secret", "neon", "", false},
+		{"no signature", "secret", "neon", "1800000000", false},
+	} {
+		s := &server{cfg: &sitelib.Config{PreviewSecret: c.secret}}
+		if got := s.validPreviewToken(c.pack, c.token, now); got != c.valid {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.valid)
+		}
+	}
+}
@@ -1,22 +1,128 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
 )
 
+// defaultRedirects are legacy pages of the old site
+var defaultRedirects = []sitelib.RedirectRule{
+	{Source: "/ru", Target: "/", Lang: "ru", PreserveQuery: true},
+	{Source: "/ru.html", Target: "/", Lang: "ru", PreserveQuery: true},
+	{Source: "/streamer-ru", Target: "/streamer", Lang: "ru", PreserveQuery: true},
+	{Source: "/model.html", Target: "/streamer"},
+	{Source: "/model-ru.html", Target: "/streamer", Lang: "ru", PreserveQuery: true},
+}
+
+var routeVarRegex = regexp.MustCompile(`\{([^:}]+)(?::[^}]*)?\}`)
+
 type redirectSubdHandler struct {
-	subd string
-	url  string
-	code int
+	baseDomain string
+	rule       sitelib.RedirectRule
+}
+
+func newRedirectSubdHandler(baseDomain string, rule sitelib.RedirectRule) http.Handler {
+	return &redirectSubdHandler{baseDomain: baseDomain, rule: rule}
 }
 
-func newRedirectSubdHandler(subd, url string, code int) http.Handler {
-	return &redirectSubdHandler{subd: subd, url: url, code: code}
+func (s *server) redirects() []sitelib.RedirectRule {
+	if s.cfg.Redirects == nil {
+		return defaultRedirects
+	}
+	return s.cfg.Redirects
 }
 
+func redirectStatus(rule sitelib.RedirectRule) int {
+	return valueOr(rule.Status, http.StatusMovedPermanently)
+}
+
+// expandTarget substitutes variables of the source pattern
+func expandTarget(target string, vars map[string]string) string {
+	return routeVarRegex.ReplaceAllStringFunc(target, func(v string) string {
+		return vars[routeVarRegex.FindStringSubmatch(v)[1]]
+	})
+}
+
+// ServeHTTP builds the host from the base domain,
+// so requests already on a subdomain don't get a second prefix
 func (rh *redirectSubdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	url := *r.URL
-	url.Host = rh.subd + "." + r.Host
-	url.Path = rh.url
-	http.Redirect(w, r, url.String(), rh.code)
+	target, err := url.Parse(expandTarget(rh.rule.Target, mux.Vars(r)))
+	if err != nil {
+		notFoundError(w)
+		return
+	}
+	if rh.rule.Lang != "" {
+		_, port := splitHostPort(r.Host)
+		target.Scheme = r.URL.Scheme
+		target.Host = localePrefixes[rh.rule.Lang] + rh.baseDomain
+		if port != "" {
+			target.Host = net.JoinHostPort(target.Host, port)
+		}
+	}
+	if rh.rule.PreserveQuery && r.URL.RawQuery != "" {
+		if target.RawQuery != "" {
+			target.RawQuery += "&"
+		}
+		target.RawQuery += r.URL.RawQuery
+	}
+	http.Redirect(w, r, target.String(), redirectStatus(rh.rule))
+}
+
+// validateRedirects checks redirect rules and follows every rule
+// through the others to find loops
+func validateRedirects(rules []sitelib.RedirectRule) error {
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.Source, "/") || !strings.HasPrefix(rule.Target, "/") {
+			return fmt.Errorf("redirect %q to %q: paths must be absolute", rule.Source, rule.Target)
+		}
+		if _, ok := localePrefixes[rule.Lang]; rule.Lang != "" && !ok {
+			return fmt.Errorf("redirect %q: unknown language %q", rule.Source, rule.Lang)
+		}
+		switch status := redirectStatus(rule); status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return fmt.Errorf("redirect %q: invalid status %d", rule.Source, status)
+		}
+	}
+	router := mux.NewRouter()
+	routes := map[*mux.Route]int{}
+	for i, rule := range rules {
+		route := router.NewRoute().Path(rule.Source)
+		if err := route.GetError(); err != nil {
+			return fmt.Errorf("redirect %q: %w", rule.Source, err)
+		}
+		routes[route] = i
+	}
+	for i, rule := range rules {
+		visited := map[int]bool{i: true}
+		vars := map[string]string{}
+		for _, m := range routeVarRegex.FindAllStringSubmatch(rule.Source, -1) {
+			vars[m[1]] = "x"
+		}
+		current := rule
+		for {
+			target, err := url.Parse(expandTarget(current.Target, vars))
+			if err != nil {
+				return fmt.Errorf("redirect %q: %w", current.Source, err)
+			}
+			var match mux.RouteMatch
+			if !router.Match(&http.Request{Method: http.MethodGet, URL: target}, &match) {
+				break
+			}
+			next := routes[match.Route]
+			if visited[next] {
+				return fmt.Errorf("redirect %q loops through %q", rule.Source, current.Target)
+			}
+			visited[next] = true
+			current, vars = rules[next], match.Vars
+		}
+	}
+	return nil
 }
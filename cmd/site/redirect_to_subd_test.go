@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"github.com/gorilla/mux"
+)
+
+func TestRedirects(t *testing.T) {
+	rules := append([]sitelib.RedirectRule{
+		{Source: "/p/{pack}", Target: "/chic/p/{pack}", Status: http.StatusFound},
+		{Source: "/ru/p/{pack:[a-z]+}", Target: "/chic/p/{pack}?from=ru", Lang: "ru", PreserveQuery: true},
+	}, defaultRedirects...)
+	r := mux.NewRouter()
+	for _, rule := range rules {
+		r.Handle(rule.Source, newRedirectSubdHandler("siren.chat", rule))
+	}
+	for _, c := range []struct {
+		url      string
+		status   int
+		location string
+	}{
+		{"https://siren.chat/ru?a=1", http.StatusMovedPermanently, "https://ru.siren.chat/?a=1"},
+		{"https://ru.siren.chat/ru.html", http.StatusMovedPermanently, "https://ru.siren.chat/"},
+		{"https://siren.chat:8080/streamer-ru", http.StatusMovedPermanently, "https://ru.siren.chat:8080/streamer"},
+		{"https://siren.chat/model.html?a=1", http.StatusMovedPermanently, "/streamer"},
+		{"https://ru.siren.chat/model-ru.html", http.StatusMovedPermanently, "https://ru.siren.chat/streamer"},
+		{"https://ru.siren.chat/p/neon?a=1", http.StatusFound, "/chic/p/neon"},
+		{"https://siren.chat/ru/p/neon?a=1", http.StatusMovedPermanently, "https://ru.siren.chat/chic/p/neon?from=ru&a=1"},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if rec.Code != c.status || rec.Header().Get("Location") != c.location {
+			t.Errorf("%s: got %d %q, want %d %q", c.url, rec.Code, rec.Header().Get("Location"), c.status, c.location)
+		}
+	}
+}
+
+func TestValidateRedirects(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		rules []sitelib.RedirectRule
+		valid bool
+	}{
+		{"default", defaultRedirects, true},
+		{"chain", []sitelib.RedirectRule{{Source: "/a", Target: "/b"}, {Source: "/b", Target: "/c"}}, true},
+		{"self", []sitelib.RedirectRule{{Source: "/a", Target: "/a", Lang: "ru"}}, false},
+		{"cycle", []sitelib.RedirectRule{{Source: "/a", Target: "/b"}, {Source: "/b", Target: "/c"}, {Source: "/c", Target: "/a"}}, false},
+		{"pattern cycle", []sitelib.RedirectRule{{Source: "/a/{x}", Target: "/b/{x}"}, {Source: "/b/{y}", Target: "/a/{y}"}}, false},
+		{"relative", []sitelib.RedirectRule{{Source: "/a", Target: "b"}}, false},
+		{"language", []sitelib.RedirectRule{{Source: "/a", Target: "/b", Lang: "de"}}, false},
+		{"status", []sitelib.RedirectRule{{Source: "/a", Target: "/b", Status: http.StatusOK}}, false},
+	} {
+		if err := validateRedirects(c.rules); (err == nil) != c.valid {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+	}
+}
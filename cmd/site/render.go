@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+const (
+	// rasterDensity makes rendered icons sharp on high density screens
+	rasterDensity = 2
+
+	// generateTimeout limits the work shared by concurrent requests,
+	// it doesn't depend on the request that started it
+	generateTimeout = time.Minute
+)
+
+var errInvalidHeight = errors.New("invalid height")
+
+// rasterHeight is the height of the icon rendered for the code of the given size,
+// sizes not offered on the pack page are replaced with the closest offered one
+func rasterHeight(pack *sitelib.PackV2, icon sitelib.IconV2, size int) int {
+	if icon.Width <= 0 {
+		return naturalHeight(icon)
+	}
+	closest := codeSizes[0]
+	for _, s := range codeSizes {
+		if abs(s-size) < abs(closest-size) {
+			closest = s
+		}
+	}
+	width := float64(closest*rasterDensity**pack.ChaturbateIconsScale) / 100
+	return max(int(math.Round(width*icon.Height/icon.Width)), 1)
+}
+
+// renderHeight parses the requested height, the natural icon height is used if it's missing.
+// Only heights used by the generated code are rendered
+// so that requests can't fill the bucket with arbitrary sizes.
+func renderHeight(h string, pack *sitelib.PackV2, icon sitelib.IconV2) (int, error) {
+	if h == "" {
+		return naturalHeight(icon), nil
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, errInvalidHeight
+	}
+	if height == naturalHeight(icon) || slices.ContainsFunc(codeSizes, func(size int) bool { return rasterHeight(pack, icon, size) == height }) {
+		return height, nil
+	}
+	return 0, errInvalidHeight
+}
+
+func naturalHeight(icon sitelib.IconV2) int {
+	return max(int(math.Round(icon.Height)), 1)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func encodeImage(img image.Image, format iconFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == webpFormat {
+		err = nativewebp.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderedIconKey is the key both in the bucket and in the disk cache
+func renderedIconKey(pack *sitelib.PackV2, name string, height int, format iconFormat) string {
+	return pack.Name + "/rendered/" + pack.VersionedIcon(name) + ".h" + strconv.Itoa(height) + "." + format.ext
+}
+
+// renderedIcon returns the SVG icon rendered to a raster format
+func (s *server) renderedIcon(ctx context.Context, pack *sitelib.PackV2, name string, height int, format iconFormat) ([]byte, error) {
+	key := renderedIconKey(pack, name, height, format)
+	return s.generated(ctx, key, format.contentType, func(ctx context.Context) ([]byte, error) {
+		svg, err := sitelib.SVGIcon(ctx, s.fetchIcon, pack, name)
+		if err != nil {
+			return nil, err
+		}
+		img, err := sitelib.Rasterize(svg, height)
+		if err != nil {
+			return nil, err
+		}
+		iconRenders.WithLabelValues(format.ext).Inc()
+		return encodeImage(img, format)
+	})
+}
+
+// generated returns the generated file by its key.
+// Files are looked up in memory, on disk and in the bucket before generating them,
+// new files are stored in all these places.
+// Keys must change whenever the content changes.
+func (s *server) generated(
+	ctx context.Context,
+	key string,
+	contentType string,
+	generate func(ctx context.Context) ([]byte, error),
+) ([]byte, error) {
+	if data, ok := s.iconCache.get(key); ok && data != nil {
+		return data, nil
+	}
+	data, err, _ := s.renders.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), generateTimeout)
+		defer cancel()
+		if data := s.readRenderCache(key); data != nil {
+			s.iconCache.add(key, data)
+			return data, nil
+		}
+		data, err := sitelib.Download(ctx, s.bucket, s.cfg, key)
+		if err == nil {
+			s.writeRenderCache(key, data)
+			s.iconCache.add(key, data)
+			return data, nil
+		}
+		if !sitelib.IsNotFound(err) {
+			return nil, err
+		}
+		if data, err = generate(ctx); err != nil {
+			return nil, err
+		}
+		s.writeRenderCache(key, data)
+		s.iconCache.add(key, data)
+		if err := sitelib.Upload(ctx, s.bucket, s.cfg, key, contentType, data); err != nil {
+			slog.Warn("cannot upload generated file", "key", key, "error", err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+func (s *server) readRenderCache(key string) []byte {
+	if s.cfg.RenderCacheDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.cfg.RenderCacheDir, filepath.FromSlash(key)))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("cannot read generated file", "key", key, "error", err)
+		}
+		return nil
+	}
+	return data
+}
+
+// writeRenderCache writes through a temporary file so readers never see partial files
+func (s *server) writeRenderCache(key string, data []byte) {
+	if s.cfg.RenderCacheDir == "" {
+		return
+	}
+	path := filepath.Join(s.cfg.RenderCacheDir, filepath.FromSlash(key))
+	err := func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.CreateTemp(filepath.Dir(path), ".render-*")
+		if err != nil {
+			return err
+		}
+		defer func() { _ = os.Remove(f.Name()) }()
+		if _, err := f.Write(data); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Rename(f.Name(), path)
+	}()
+	if err != nil {
+		slog.Warn("cannot write generated file", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestRenderHeight(t *testing.T) {
+	pack := benchmarkPack()
+	icon := pack.Icons["siren"]
+	for _, c := range []struct {
+		h      string
+		height int
+		ok     bool
+	}{
+		{"", 120, true},
+		{"120", 120, true},
+		{"117", 117, true},
+		{"91", 91, true},
+		{"194", 194, true},
+		{"118", 0, false},
+		{"512", 0, false},
+		{"0", 0, false},
+		{"-117", 0, false},
+		{"abc", 0, false},
+	} {
+		height, err := renderHeight(c.h, pack, icon)
+		if height != c.height || (err == nil) != c.ok {
+			t.Errorf("%q: got %d %v, want %d", c.h, height, err, c.height)
+		}
+	}
+	if height := rasterHeight(pack, icon, 55); height != 117 {
+		t.Errorf("size 55 isn't rounded to the offered size 54: got %d", height)
+	}
+}
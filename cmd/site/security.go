@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type cspNonceKey struct{}
+
+// testPagePolicy isolates the generated bio code served as is,
+// it may contain anything users put into parameters
+const testPagePolicy = "sandbox; default-src 'none'; img-src https: data:; style-src 'unsafe-inline'; font-src https: data:"
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// pagePolicy allows only scripts carrying the nonce and scripts they load,
+// the Twitter widget is loaded this way
+func pagePolicy(nonce string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'nonce-" + nonce + "' 'strict-dynamic'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' https: data:",
+		"font-src 'self'",
+		"connect-src 'self'",
+		"frame-src https://platform.twitter.com https://syndication.twitter.com",
+		"object-src 'none'",
+		"base-uri 'none'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}
+
+// securityHeaders sets headers common for all responses,
+// handlers can replace the policy for their responses
+func (s *server) securityHeaders(h http.Handler) http.Handler {
+	https := strings.HasPrefix(s.cfg.BaseURL, "https://")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := newCSPNonce()
+		hs := w.Header()
+		hs.Set("Content-Security-Policy", pagePolicy(nonce))
+		hs.Set("X-Content-Type-Options", "nosniff")
+		hs.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		hs.Set("X-Frame-Options", "DENY")
+		if https {
+			hs.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
+	})
+}
+
+// siteOrigin reports whether the request comes from a page of the site,
+// that is the base domain or one of its locale subdomains
+func (s *server) siteOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "same-site" {
+		return false
+	}
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+	host := origin.Hostname()
+	if base, err := url.Parse(s.cfg.BaseURL); err == nil && host == base.Hostname() {
+		return true
+	}
+	for _, prefix := range localePrefixes {
+		if host == prefix+s.cfg.BaseDomain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+var defaultRobotsDisallow = []string{"/chic/test/", "/chic/code/", "/chic/like/"}
+
+type sitemapURLSet struct {
+	XMLName    xml.Name     `xml:"urlset"`
+	Xmlns      string       `xml:"xmlns,attr"`
+	XmlnsXhtml string       `xml:"xmlns:xhtml,attr"`
+	URLs       []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string             `xml:"loc"`
+	LastMod    string             `xml:"lastmod,omitempty"`
+	Alternates []sitemapAlternate `xml:"xhtml:link"`
+}
+
+type sitemapAlternate struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+type siteLanguage struct {
+	lang    string
+	baseURL string
+}
+
+func (s *server) robotsDisallow() []string {
+	if s.cfg.RobotsDisallow == nil {
+		return defaultRobotsDisallow
+	}
+	return s.cfg.RobotsDisallow
+}
+
+func (s *server) disallowed(path string) bool {
+	for _, prefix := range s.robotsDisallow() {
+		if prefix != "" && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// siteLanguages returns base URLs of localized domains, English goes first as the default one
+func (s *server) siteLanguages() []siteLanguage {
+	if s.cfg.Lang != "" {
+		return []siteLanguage{{lang: s.cfg.Lang, baseURL: s.cfg.BaseURL}}
+	}
+	return []siteLanguage{
+		{lang: "en", baseURL: "https://" + s.cfg.BaseDomain},
+		{lang: "ru", baseURL: "https://ru." + s.cfg.BaseDomain},
+	}
+}
+
+// packModified returns the latest time the pack page changed
+func packModified(pack *sitelib.PackV2) time.Time {
+	return unixTime(max(pack.CreatedAt, pack.UpdatedAt, pack.PublishAt))
+}
+
+// sitemapPages expands localized routes into paths with their modification times
+func (s *server) sitemapPages() (paths []string, modified map[string]time.Time) {
+	_, enabledPacks := s.packState()
+	var latest time.Time
+	for _, pack := range enabledPacks {
+		if t := packModified(&pack); t.After(latest) {
+			latest = t
+		}
+	}
+	modified = map[string]time.Time{}
+	for _, route := range s.localizedRoutes {
+		if s.disallowed(route) {
+			continue
+		}
+		if !strings.Contains(route, "{pack}") {
+			paths = append(paths, route)
+			if route == "/chic" {
+				modified[route] = latest
+			}
+			continue
+		}
+		for _, pack := range enabledPacks {
+			path := strings.ReplaceAll(route, "{pack}", pack.Name)
+			paths = append(paths, path)
+			modified[path] = packModified(&pack)
+		}
+	}
+	return paths, modified
+}
+
+func (s *server) sitemapHandler(w http.ResponseWriter, _ *http.Request) error {
+	langs := s.siteLanguages()
+	paths, modified := s.sitemapPages()
+	set := sitemapURLSet{
+		Xmlns:      "http://www.sitemaps.org/schemas/sitemap/0.9",
+		XmlnsXhtml: "http://www.w3.org/1999/xhtml",
+	}
+	for _, path := range paths {
+		var alternates []sitemapAlternate
+		if len(langs) > 1 {
+			for _, l := range langs {
+				alternates = append(alternates, sitemapAlternate{Rel: "alternate", Hreflang: l.lang, Href: l.baseURL + path})
+			}
+			alternates = append(alternates, sitemapAlternate{Rel: "alternate", Hreflang: "x-default", Href: langs[0].baseURL + path})
+		}
+		lastMod := ""
+		if t, ok := modified[path]; ok && !t.IsZero() {
+			lastMod = t.Format(time.DateOnly)
+		}
+		for _, l := range langs {
+			set.URLs = append(set.URLs, sitemapURL{Loc: l.baseURL + path, LastMod: lastMod, Alternates: alternates})
+		}
+	}
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, err = w.Write(append([]byte(xml.Header), out...))
+	return err
+}
+
+func (s *server) robotsHandler(w http.ResponseWriter, _ *http.Request) error {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, path := range s.robotsDisallow() {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+	b.WriteString("\nSitemap: " + s.cfg.BaseURL + "/sitemap.xml\n")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
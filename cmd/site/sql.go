@@ -2,23 +2,32 @@ package main
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (s *server) mustExec(query string, args ...interface{}) {
-	_, err := s.db.Exec(context.Background(), query, args...)
-	checkErr(err)
+func startDBSpan(ctx context.Context, operation string, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "db "+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", strings.Join(strings.Fields(query), " ")),
+	))
 }
 
-func (s *server) mustQuery(query string, args ...interface{}) pgx.Rows {
-	result, err := s.db.Query(context.Background(), query, args...)
-	checkErr(err)
-	return result
+func (s *server) exec(ctx context.Context, query string, args ...interface{}) (err error) {
+	defer observeDBQuery("exec", time.Now())
+	ctx, span := startDBSpan(ctx, "exec", query)
+	defer func() { endSpan(span, err) }()
+	_, err = s.db.Exec(ctx, query, args...)
+	return err
 }
 
-func (s *server) mustInt(query string, args ...interface{}) (result int) {
-	row := s.db.QueryRow(context.Background(), query, args...)
-	checkErr(row.Scan(&result))
-	return result
+func (s *server) query(ctx context.Context, query string, args ...interface{}) (rows pgx.Rows, err error) {
+	defer observeDBQuery("query", time.Now())
+	ctx, span := startDBSpan(ctx, "query", query)
+	defer func() { endSpan(span, err) }()
+	return s.db.Query(ctx, query, args...)
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	ht "html/template"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+)
+
+// Structured data follows https://schema.org and is embedded as JSON-LD
+
+const schemaContext = "https://schema.org"
+
+type ldOrganization struct {
+	Context string   `json:"@context,omitempty"`
+	Type    string   `json:"@type"`
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Logo    string   `json:"logo,omitempty"`
+	SameAs  []string `json:"sameAs,omitempty"`
+}
+
+type ldImage struct {
+	Type       string `json:"@type"`
+	Name       string `json:"name"`
+	ContentURL string `json:"contentUrl"`
+}
+
+type ldAggregateRating struct {
+	Type        string  `json:"@type"`
+	RatingValue float64 `json:"ratingValue"`
+	BestRating  int     `json:"bestRating"`
+	WorstRating int     `json:"worstRating"`
+	RatingCount int     `json:"ratingCount"`
+}
+
+type ldImageGallery struct {
+	Context         string             `json:"@context"`
+	Type            string             `json:"@type"`
+	Name            string             `json:"name"`
+	URL             string             `json:"url"`
+	InLanguage      string             `json:"inLanguage"`
+	Image           string             `json:"image"`
+	DatePublished   string             `json:"datePublished,omitempty"`
+	DateModified    string             `json:"dateModified,omitempty"`
+	IsAccessibleFor bool               `json:"isAccessibleForFree"`
+	Publisher       ldOrganization     `json:"publisher"`
+	AssociatedMedia []ldImage          `json:"associatedMedia"`
+	AggregateRating *ldAggregateRating `json:"aggregateRating,omitempty"`
+}
+
+type ldListItem struct {
+	Type     string `json:"@type"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Item     string `json:"item,omitempty"`
+}
+
+type ldBreadcrumbList struct {
+	Context         string       `json:"@context"`
+	Type            string       `json:"@type"`
+	ItemListElement []ldListItem `json:"itemListElement"`
+}
+
+// jsonLD renders a script element, json.Marshal escapes <, > and & so the data can't close it
+func jsonLD(data any) (ht.HTML, error) {
+	if data == nil {
+		return "", nil
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return ht.HTML(`<script type="application/ld+json">` + string(content) + `</script>`), nil
+}
+
+func (s *server) organization() ldOrganization {
+	org := ldOrganization{
+		Type:   "Organization",
+		Name:   "SIREN",
+		URL:    s.cfg.BaseURL,
+		SameAs: []string{"https://twitter.com/siren_tlg"},
+	}
+	if s.cfg.AssetsBucketURL != "" {
+		org.Logo = s.cfg.AssetsBucketURL + "/icons/siren-back-1024x1024.png"
+	}
+	return org
+}
+
+func (s *server) organizationData() ldOrganization {
+	org := s.organization()
+	org.Context = schemaContext
+	return org
+}
+
+// ratingFromVotes maps likes to the best rating and dislikes to the worst one
+func ratingFromVotes(likes, dislikes int) *ldAggregateRating {
+	total := likes + dislikes
+	if total == 0 {
+		return nil
+	}
+	value := 1 + 4*float64(likes)/float64(total)
+	return &ldAggregateRating{
+		Type:        "AggregateRating",
+		RatingValue: math.Round(value*10) / 10,
+		BestRating:  5,
+		WorstRating: 1,
+		RatingCount: total,
+	}
+}
+
+func ldDate(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.DateOnly)
+}
+
+func (s *server) packData(langBaseURL ht.URL, lang string, pack *sitelib.PackV2, likes, dislikes int) ldImageGallery {
+	names := make([]string, 0, len(pack.Icons))
+	for name := range pack.Icons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	media := make([]ldImage, 0, len(names))
+	for _, name := range names {
+		media = append(media, ldImage{
+			Type:       "ImageObject",
+			Name:       name,
+			ContentURL: s.cfg.BaseURL + iconURL(pack, name, pack.FinalType),
+		})
+	}
+	return ldImageGallery{
+		Context:         schemaContext,
+		Type:            "ImageGallery",
+		Name:            pack.HumanName,
+		URL:             string(langBaseURL) + "/chic/p/" + pack.Name,
+		InLanguage:      lang,
+		Image:           s.cfg.BaseURL + "/chic/banner/" + pack.Name + "/1200x630.jpg?rev=" + pack.BannerRevision(),
+		DatePublished:   ldDate(max(pack.CreatedAt, pack.PublishAt)),
+		DateModified:    ldDate(pack.UpdatedAt),
+		IsAccessibleFor: true,
+		Publisher:       s.organization(),
+		AssociatedMedia: media,
+		AggregateRating: ratingFromVotes(likes, dislikes),
+	}
+}
+
+// breadcrumbList converts the header breadcrumbs,
+// the last one is the current page and has no URL
+func breadcrumbList(langBaseURL ht.URL, breadcrumbs []any) (*ldBreadcrumbList, error) {
+	list := &ldBreadcrumbList{Context: schemaContext, Type: "BreadcrumbList"}
+	for i, bc := range breadcrumbs {
+		m, ok := bc.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid breadcrumb")
+		}
+		label, _ := m["Label"].(string)
+		item := ldListItem{Type: "ListItem", Position: i + 1, Name: label}
+		if u, _ := m["URL"].(string); u != "" {
+			item.Item = string(langBaseURL) + u
+		}
+		list.ItemListElement = append(list.ItemListElement, item)
+	}
+	return list, nil
+}
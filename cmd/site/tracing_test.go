@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bcmk/siren-site/v3/sitelib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestChaturbateCodeSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	s := &server{cfg: &sitelib.Config{BaseURL: "https://siren.chat"}, assets: embeddedAssets}
+	s.codeGeneratorTemplate = s.parseHTMLTemplate("common/icons-code-generator.gohtml")
+	s.minifier = newCodeMinifier()
+	pack := benchmarkPack()
+	for _, size := range []string{"54", "abc"} {
+		_, _ = s.chaturbateCode(context.Background(), pack, map[string]string{"siren": "abc", "size": size})
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for _, span := range spans {
+		if span.Name != "chaturbateCode" {
+			t.Errorf("got span %q", span.Name)
+		}
+		if !hasAttribute(span.Attributes, attribute.String("pack", pack.Name)) {
+			t.Errorf("span has no pack attribute: %v", span.Attributes)
+		}
+	}
+	if spans[0].Status.Code == codes.Error {
+		t.Errorf("valid code generation is marked as failed")
+	}
+	if spans[1].Status.Code != codes.Error || len(spans[1].Events) == 0 {
+		t.Errorf("error isn't recorded: %+v", spans[1].Status)
+	}
+}
+
+func hasAttribute(attributes []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attributes {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
@@ -15,7 +15,7 @@ module.exports = {
     path: path.resolve(__dirname, 'static'),
     publicPath: '/static/',
     filename: 'bundle.js',
-    clean: true
+    clean: { keep: /\.gitkeep$/ }
   },
   module: {
     rules: [
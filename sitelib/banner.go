@@ -0,0 +1,159 @@
+package sitelib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// BannerSize is a supported banner size
+type BannerSize struct {
+	Width  int
+	Height int
+}
+
+// BannerSizes are sizes of generated banners: a square one and one for social previews
+var BannerSizes = []BannerSize{{900, 900}, {1200, 630}}
+
+const maxBannerIcons = 12
+
+var (
+	bannerBackground = color.RGBA{R: 0x21, G: 0x25, B: 0x29, A: 0xff}
+	bannerForeground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	bannerFont       = func() *opentype.Font {
+		f, err := opentype.Parse(gobold.TTF)
+		if err != nil {
+			panic(err)
+		}
+		return f
+	}()
+)
+
+// BannerIcons returns names of icons shown on the banner, the siren icon goes first
+func BannerIcons(pack *PackV2) []string {
+	names := make([]string, 0, len(pack.Icons))
+	for name := range pack.Icons {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == "siren") != (names[j] == "siren") {
+			return names[i] == "siren"
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > maxBannerIcons {
+		names = names[:maxBannerIcons]
+	}
+	return names
+}
+
+// bannerGrid chooses the number of columns giving the largest cells
+func bannerGrid(n int, width, height int) (cols, rows, cell int) {
+	for c := 1; c <= n; c++ {
+		r := (n + c - 1) / c
+		if size := min(width/c, height/r); size > cell {
+			cols, rows, cell = c, r, size
+		}
+	}
+	return
+}
+
+// RenderBanner composes a banner from the pack's icons and its human name
+func RenderBanner(ctx context.Context, fetch IconFetcher, pack *PackV2, size BannerSize) (image.Image, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size.Width, size.Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bannerBackground), image.Point{}, draw.Src)
+
+	margin := size.Height / 12
+	titleHeight := size.Height / 6
+	if err := drawTitle(img, pack.HumanName, image.Rect(margin, margin, size.Width-margin, margin+titleHeight)); err != nil {
+		return nil, err
+	}
+
+	names := BannerIcons(pack)
+	if len(names) == 0 {
+		return img, nil
+	}
+	area := image.Rect(margin, 2*margin+titleHeight, size.Width-margin, size.Height-margin)
+	cols, rows, cell := bannerGrid(len(names), area.Dx(), area.Dy())
+	iconHeight := cell * 4 / 5
+	left := area.Min.X + (area.Dx()-cols*cell)/2
+	top := area.Min.Y + (area.Dy()-rows*cell)/2
+	for i, name := range names {
+		icon, err := IconImage(ctx, fetch, pack, name, iconHeight)
+		if err != nil {
+			return nil, err
+		}
+		b := icon.Bounds()
+		if b.Dx() > iconHeight {
+			scaled := image.NewRGBA(image.Rect(0, 0, iconHeight, max(b.Dy()*iconHeight/b.Dx(), 1)))
+			draw.CatmullRom.Scale(scaled, scaled.Bounds(), icon, b, draw.Over, nil)
+			icon, b = scaled, scaled.Bounds()
+		}
+		x := left + (i%cols)*cell + (cell-b.Dx())/2
+		y := top + (i/cols)*cell + (cell-b.Dy())/2
+		draw.Draw(img, image.Rect(x, y, x+b.Dx(), y+b.Dy()), icon, b.Min, draw.Over)
+	}
+	return img, nil
+}
+
+// drawTitle draws the text centered in the rectangle shrinking it to fit the width
+func drawTitle(img *image.RGBA, text string, rect image.Rectangle) error {
+	size := float64(rect.Dy())
+	for {
+		face, err := opentype.NewFace(bannerFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(bannerForeground), Face: face}
+		width := d.MeasureString(text).Ceil()
+		if width <= rect.Dx() || size <= 8 {
+			metrics := face.Metrics()
+			textHeight := (metrics.Ascent + metrics.Descent).Ceil()
+			d.Dot = fixed.P(
+				rect.Min.X+(rect.Dx()-width)/2,
+				rect.Min.Y+(rect.Dy()-textHeight)/2+metrics.Ascent.Ceil(),
+			)
+			d.DrawString(text)
+			return face.Close()
+		}
+		if err := face.Close(); err != nil {
+			return err
+		}
+		size = math.Floor(size * 0.9)
+	}
+}
+
+// EncodeBanner encodes the banner as JPEG
+func EncodeBanner(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BannerRevision changes whenever the banner content changes,
+// the human name can be overridden on the site without a new pack revision
+func (p *PackV2) BannerRevision() string {
+	sum := sha256.Sum256([]byte(p.HumanName))
+	return fmt.Sprintf("%d-%s", p.Revision, hex.EncodeToString(sum[:4]))
+}
+
+// BannerKey returns the bucket key of the banner,
+// it contains the banner revision so banners are regenerated when the pack changes
+func BannerKey(pack *PackV2, size BannerSize) string {
+	return fmt.Sprintf("%s/banners/%dx%d.r%s.jpg", pack.Name, size.Width, size.Height, pack.BannerRevision())
+}
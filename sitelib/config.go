@@ -34,28 +34,75 @@ type PackV2 struct {
 	Disable              bool              `json:"disable"`
 	FinalType            string            `json:"final_type"`
 	CreatedAt            int64             `json:"created_at"`
+	PublishAt            int64             `json:"publish_at,omitempty"`   // unix time, published immediately if zero
+	UnpublishAt          int64             `json:"unpublish_at,omitempty"` // unix time, never unpublished if zero
 	Revision             int64             `json:"revision"`
 	InputType            string            `json:"input_type"`
 	Icons                map[string]IconV2 `json:"icons"`
+	License              string            `json:"license,omitempty"` // the default license is used if empty
 
-	Name string `json:"-"`
+	Name      string `json:"-"`
+	UpdatedAt int64  `json:"-"` // modification time of the pack config in the bucket
+}
+
+// Published reports whether the pack is scheduled to be shown at the moment
+func (p *PackV2) Published(now time.Time) bool {
+	if p.PublishAt != 0 && now.Unix() < p.PublishAt {
+		return false
+	}
+	return p.UnpublishAt == 0 || now.Unix() < p.UnpublishAt
+}
+
+// RedirectRule represents a redirect from a legacy URL
+type RedirectRule struct {
+	Source        string `mapstructure:"source"`         // path or a route pattern like /old/{pack}
+	Target        string `mapstructure:"target"`         // path, can use variables of the source pattern
+	Lang          string `mapstructure:"lang"`           // language subdomain of the target, the current host if empty
+	Status        int    `mapstructure:"status"`         // zero means 301
+	PreserveQuery bool   `mapstructure:"preserve_query"` // keep the query string of the request
 }
 
 // Config represents site or converter config
 type Config struct {
-	ConnectionString Secret `mapstructure:"connection_string"`
-	ListenAddress    string `mapstructure:"listen_address"`
-	BaseURL          string `mapstructure:"base_url"`
-	BaseDomain       string `mapstructure:"base_domain"`
-	BucketName       string `mapstructure:"bucket_name"`
-	BucketRegion     string `mapstructure:"bucket_region"`
-	BucketEndpoint   string `mapstructure:"bucket_endpoint"`
-	BucketAccessKey  string `mapstructure:"bucket_access_key"`
-	BucketSecretKey  Secret `mapstructure:"bucket_secret_key"`
-	BaseBucketURL    string `mapstructure:"base_bucket_url"`
-	AssetsBucketURL  string `mapstructure:"assets_bucket_url"`
-	Debug            bool   `mapstructure:"debug"`
-	Lang             string // set from --lang flag, not from config file
+	ConnectionString     Secret         `mapstructure:"connection_string"`
+	ListenAddress        string         `mapstructure:"listen_address"`
+	MetricsListenAddress string         `mapstructure:"metrics_listen_address"` // metrics are served on the main address if empty
+	BaseURL              string         `mapstructure:"base_url"`
+	BaseDomain           string         `mapstructure:"base_domain"`
+	TrustedProxies       []string       `mapstructure:"trusted_proxies"` // addresses or CIDR ranges allowed to set X-Forwarded-Proto
+	BucketName           string         `mapstructure:"bucket_name"`
+	BucketRegion         string         `mapstructure:"bucket_region"`
+	BucketEndpoint       string         `mapstructure:"bucket_endpoint"`
+	BucketAccessKey      string         `mapstructure:"bucket_access_key"`
+	BucketSecretKey      Secret         `mapstructure:"bucket_secret_key"`
+	BaseBucketURL        string         `mapstructure:"base_bucket_url"`
+	AssetsBucketURL      string         `mapstructure:"assets_bucket_url"`
+	FeaturedPack         string         `mapstructure:"featured_pack"`  // shown first on the packs page, the newest pack if empty
+	AdminUsername        string         `mapstructure:"admin_username"` // basic authentication is disabled if empty
+	AdminPassword        Secret         `mapstructure:"admin_password"`
+	AdminTokens          []Secret       `mapstructure:"admin_tokens"`   // bearer tokens allowed to access admin pages
+	PreviewSecret        Secret         `mapstructure:"preview_secret"` // preview links are disabled if empty
+	PreviewTTL           time.Duration  `mapstructure:"preview_ttl"`    // zero means the default
+	Debug                bool           `mapstructure:"debug"`
+	LogFormat            string         `mapstructure:"log_format"` // "text" (default) or "json"
+	TracingEnabled       bool           `mapstructure:"tracing_enabled"`
+	OTLPEndpoint         string         `mapstructure:"otlp_endpoint"` // host:port of the OTLP/HTTP collector, the exporter default if empty
+	OTLPInsecure         bool           `mapstructure:"otlp_insecure"`
+	TracingSampleRatio   *float64       `mapstructure:"tracing_sample_ratio"`   // every trace is sampled if not set
+	CodeCacheMaxEntries  int            `mapstructure:"code_cache_max_entries"` // zero means the default
+	CodeCacheMaxBytes    int            `mapstructure:"code_cache_max_bytes"`   // zero means the default
+	IconCacheMaxBytes    int            `mapstructure:"icon_cache_max_bytes"`   // zero means the default
+	RenderCacheDir       string         `mapstructure:"render_cache_dir"`       // generated images aren't cached on disk if empty
+	RobotsDisallow       []string       `mapstructure:"robots_disallow"`        // the default list is used if not set
+	Redirects            []RedirectRule `mapstructure:"redirects"`              // the legacy redirects are used if not set
+	ReadTimeout          time.Duration  `mapstructure:"read_timeout"`           // zero means the default
+	ReadHeaderTimeout    time.Duration  `mapstructure:"read_header_timeout"`    // zero means the default
+	WriteTimeout         time.Duration  `mapstructure:"write_timeout"`          // zero means the default
+	IdleTimeout          time.Duration  `mapstructure:"idle_timeout"`           // zero means the default
+	MaxHeaderBytes       int            `mapstructure:"max_header_bytes"`       // zero means the default
+	ShutdownTimeout      time.Duration  `mapstructure:"shutdown_timeout"`       // zero means the default
+	Lang                 string         // set from --lang flag, not from config file
+	AssetsDir            string         // set from --assets-dir flag, not from config file
 }
 
 type configFile struct {
@@ -135,25 +182,27 @@ func stringToSliceHookFunc(sep string) mapstructure.DecodeHookFunc {
 		if f.Kind() != reflect.String {
 			return data, nil
 		}
-		if t != reflect.SliceOf(f) {
+		if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.String {
 			return data, nil
 		}
 
 		raw := data.(string)
 		if raw == "" {
-			return []string{}, nil
+			return reflect.MakeSlice(t, 0, 0).Interface(), nil
 		}
 
-		result := strings.Split(raw, sep)
-		for k, v := range result {
-			result[k] = strings.TrimLeft(v, " ")
+		parts := strings.Split(raw, sep)
+		result := reflect.MakeSlice(t, len(parts), len(parts))
+		for k, v := range parts {
+			result.Index(k).SetString(strings.TrimLeft(v, " "))
 		}
-		return result, nil
+		return result.Interface(), nil
 	}
 }
 
 var cfgPath = pflag.StringP("config", "c", "", "path to a config file (overrides default search)")
 var langFlag = pflag.String("lang", "", "force language (e.g. 'ru')")
+var assetsDirFlag = pflag.String("assets-dir", "", "serve templates and static files from this directory instead of the embedded ones")
 
 // ReadConfig reads config file and parses it
 func ReadConfig() *Config {
@@ -200,6 +249,7 @@ func ReadConfig() *Config {
 	}))
 
 	cfg.Lang = *langFlag
+	cfg.AssetsDir = *assetsDirFlag
 
 	return cfg
 }
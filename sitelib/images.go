@@ -0,0 +1,118 @@
+package sitelib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/draw"
+)
+
+// IconFetcher returns the bucket object by its key or nil if it doesn't exist
+type IconFetcher func(ctx context.Context, key string) ([]byte, error)
+
+// BucketFetcher fetches icons directly from the bucket
+func BucketFetcher(svc *s3.Client, config *Config) IconFetcher {
+	return func(ctx context.Context, key string) ([]byte, error) {
+		data, err := Download(ctx, svc, config, key)
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+}
+
+// VersionedIcon returns the icon file name without an extension
+func (p *PackV2) VersionedIcon(name string) string {
+	icon := p.Icons[name]
+	if icon.Version == 0 {
+		return name
+	}
+	return name + ".v" + strconv.Itoa(icon.Version)
+}
+
+// Gunzip decompresses gzipped data such as svgz files
+func Gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// Rasterize renders SVG into the image of the given height keeping the aspect ratio
+func Rasterize(svg []byte, height int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(svg), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, errors.New("svg has no view box")
+	}
+	width := max(int(math.Round(float64(height)*icon.ViewBox.W/icon.ViewBox.H)), 1)
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
+
+// SVGIcon returns the uncompressed SVG source of the icon
+func SVGIcon(ctx context.Context, fetch IconFetcher, pack *PackV2, name string) ([]byte, error) {
+	base := pack.Name + "/" + pack.VersionedIcon(name)
+	data, err := fetch(ctx, base+".svgz")
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		return Gunzip(data)
+	}
+	data, err = fetch(ctx, base+".svg")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+// IconImage returns the icon as an image of the given height
+func IconImage(ctx context.Context, fetch IconFetcher, pack *PackV2, name string, height int) (image.Image, error) {
+	if pack.FinalType == "svg" {
+		svg, err := SVGIcon(ctx, fetch, pack, name)
+		if err != nil {
+			return nil, err
+		}
+		return Rasterize(svg, height)
+	}
+	data, err := fetch(ctx, pack.Name+"/"+pack.VersionedIcon(name)+".png")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fs.ErrNotExist
+	}
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if b.Dy() == 0 {
+		return nil, errors.New("empty image")
+	}
+	width := max(b.Dx()*height/b.Dy(), 1)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst, nil
+}
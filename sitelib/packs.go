@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -14,11 +16,30 @@ import (
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/bcmk/siren/lib/cmdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func download(svc *s3.Client, bucketName string, key *string) (*bytes.Buffer, error) {
-	ctx := context.Background()
+var tracer = otel.Tracer("github.com/bcmk/siren-site/v3/sitelib")
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func download(ctx context.Context, svc *s3.Client, bucketName string, key *string) (_ *bytes.Buffer, err error) {
+	ctx, span := tracer.Start(ctx, "s3 GetObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("aws.s3.bucket", bucketName),
+		attribute.String("aws.s3.key", *key),
+	))
+	defer func() { endSpan(span, err) }()
 
 	out, err := svc.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
@@ -36,9 +57,40 @@ func download(svc *s3.Client, bucketName string, key *string) (*bytes.Buffer, er
 	return &buf, nil
 }
 
-// ParsePacksV2 parses icons packs for config V2
-func ParsePacksV2(config *Config) []PackV2 {
-	ctx := context.Background()
+// Download downloads the object from the packs bucket
+func Download(ctx context.Context, svc *s3.Client, config *Config, key string) ([]byte, error) {
+	buf, err := download(ctx, svc, config.BucketName, &key)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Upload uploads the object to the packs bucket
+func Upload(ctx context.Context, svc *s3.Client, config *Config, key string, contentType string, data []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "s3 PutObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("aws.s3.bucket", config.BucketName),
+		attribute.String("aws.s3.key", key),
+	))
+	defer func() { endSpan(span, err) }()
+
+	_, err = svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(config.BucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// IsNotFound reports whether the error means that the object doesn't exist
+func IsNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	return errors.As(err, &noSuchKey)
+}
+
+// NewBucketClient creates a client for the packs bucket
+func NewBucketClient(ctx context.Context, config *Config) (*s3.Client, error) {
 	awscfg, err := awsconfig.LoadDefaultConfig(
 		ctx,
 		awsconfig.WithRegion(config.BucketRegion),
@@ -46,15 +98,28 @@ func ParsePacksV2(config *Config) []PackV2 {
 			credentials.NewStaticCredentialsProvider(config.BucketAccessKey, string(config.BucketSecretKey), ""),
 		),
 	)
-	cmdlib.CheckErr(err)
+	if err != nil {
+		return nil, err
+	}
 
-	svc := s3.NewFromConfig(awscfg, func(o *s3.Options) {
+	return s3.NewFromConfig(awscfg, func(o *s3.Options) {
 		o.UsePathStyle = true
 		o.DisableLogOutputChecksumValidationSkipped = true
 		if config.BucketEndpoint != "" {
 			o.BaseEndpoint = aws.String(config.BucketEndpoint)
 		}
-	})
+	}), nil
+}
+
+// LoadPacksV2 loads icons packs for config V2 from the bucket
+func LoadPacksV2(ctx context.Context, config *Config) (_ []PackV2, err error) {
+	ctx, span := tracer.Start(ctx, "LoadPacksV2")
+	defer func() { endSpan(span, err) }()
+
+	svc, err := NewBucketClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
 
 	var packs []PackV2
 
@@ -63,22 +128,35 @@ func ParsePacksV2(config *Config) []PackV2 {
 	})
 
 	for p.HasMorePages() {
-		page, err := p.NextPage(ctx)
-		cmdlib.CheckErr(err)
+		pageCtx, pageSpan := tracer.Start(ctx, "s3 ListObjectsV2", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("aws.s3.bucket", config.BucketName),
+		))
+		page, err := p.NextPage(pageCtx)
+		endSpan(pageSpan, err)
+		if err != nil {
+			return nil, err
+		}
 
 		for _, obj := range page.Contents {
 			if strings.HasSuffix(*obj.Key, "/config_v2.json") {
-				fmt.Printf("Parsing %s...\n", *obj.Key)
+				slog.Info("parsing pack config", "key", *obj.Key)
 
-				buf, err := download(svc, config.BucketName, obj.Key)
-				cmdlib.CheckErr(err)
+				buf, err := download(ctx, svc, config.BucketName, obj.Key)
+				if err != nil {
+					return nil, err
+				}
 
 				var pack PackV2
-				cmdlib.CheckErr(json.Unmarshal(buf.Bytes(), &pack))
+				if err := json.Unmarshal(buf.Bytes(), &pack); err != nil {
+					return nil, fmt.Errorf("cannot parse %s: %w", *obj.Key, err)
+				}
 
 				fullDirPath := filepath.Dir(*obj.Key)
 				dirName := filepath.Base(fullDirPath)
 				pack.Name = dirName
+				if obj.LastModified != nil {
+					pack.UpdatedAt = obj.LastModified.Unix()
+				}
 
 				packs = append(packs, pack)
 			}
@@ -90,11 +168,19 @@ func ParsePacksV2(config *Config) []PackV2 {
 	})
 
 	if config.Debug {
-		fmt.Println("Parsed packs configuration:")
-		out, err := json.MarshalIndent(packs, "", "  ")
-		cmdlib.CheckErr(err)
-		fmt.Println(string(out))
+		out, err := json.Marshal(packs)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("parsed packs configuration", "packs", string(out))
 	}
 
+	return packs, nil
+}
+
+// ParsePacksV2 parses icons packs for config V2
+func ParsePacksV2(config *Config) []PackV2 {
+	packs, err := LoadPacksV2(context.Background(), config)
+	cmdlib.CheckErr(err)
 	return packs
 }
@@ -1,5 +1,7 @@
 package sitelib
 
+import "log/slog"
+
 // Secret is a string type that redacts its value in JSON and logs.
 type Secret string
 
@@ -12,3 +14,8 @@ func (s Secret) MarshalJSON() ([]byte, error) {
 func (s Secret) String() string {
 	return "***"
 }
+
+// LogValue redacts the secret value in structured logs.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue("***")
+}